@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"monkey/token"
 	"strings"
 )
@@ -22,8 +23,10 @@ type Program struct {
 	Statements []Statement
 }
 type LetStatement struct {
-	Token token.Token // the token.LET token
+	Token token.Token // the token.LET or token.CONST token
 	Name  *Identifier
+	Names []*Identifier   // set instead of Name by `let (a, b) = ...`
+	Type  *TypeAnnotation // nil when not annotated
 	Value Expression
 }
 type Identifier struct {
@@ -53,6 +56,14 @@ type InfixExpression struct {
 	Operator string
 	Right    Expression
 }
+
+// PipeExpression is `left |> right`. When right is a call, left becomes its
+// first argument; otherwise right is called with left alone.
+type PipeExpression struct {
+	Token token.Token // the '|>' token
+	Left  Expression
+	Right Expression
+}
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -68,9 +79,23 @@ type BlockStatement struct {
 	Statements []Statement
 }
 type FunctionLiteral struct {
-	Token      token.Token // the 'fn' token
-	Parameters []*Identifier
-	Body       *BlockStatement
+	Token          token.Token // the 'fn' token
+	Parameters     []*Identifier
+	ParameterTypes []*TypeAnnotation // one per parameter, nil when not annotated
+	ReturnType     *TypeAnnotation   // nil when not annotated
+	Body           *BlockStatement
+	Name           string
+	IsGenerator    bool // declared with `fn*`
+}
+
+// TypeAnnotation is a written type: a name such as int, string, bool, null or
+// any, [T] for arrays, {K: V} for hashes, #{T} for sets and fn(A, B): R for
+// functions. Only the type checker looks at annotations.
+type TypeAnnotation struct {
+	Token      token.Token       // the first token of the type
+	Name       string            // the type name, or array, hash, set or fn
+	Parameters []*TypeAnnotation // element, key and value, or argument types
+	Return     *TypeAnnotation   // the result of a fn type, nil if left out
 }
 type CallExpression struct {
 	Token     token.Token // the '(' token
@@ -90,9 +115,73 @@ type IndexExpression struct {
 	Left  Expression
 	Index Expression
 }
+type SliceExpression struct {
+	Token token.Token // [
+	Left  Expression
+	Start Expression // nil when left out
+	End   Expression // nil when left out
+}
 type HashLiteral struct {
 	Token token.Token // '{'
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in source order
+}
+type SetLiteral struct {
+	Token    token.Token // '#{'
+	Elements []Expression
+}
+type TupleLiteral struct {
+	Token    token.Token // '(', or 'return' for `return a, b`
+	Elements []Expression
+}
+type ArrayComprehension struct {
+	Token   token.Token // [
+	Element Expression
+	Clause  *ComprehensionClause
+}
+type HashComprehension struct {
+	Token  token.Token // {
+	Key    Expression
+	Value  Expression
+	Clause *ComprehensionClause
+}
+
+// ComprehensionClause is the `for x in xs if cond` part of a comprehension.
+// With two variables it walks the key/value pairs of a hash or the
+// index/element pairs of an array.
+type ComprehensionClause struct {
+	Token     token.Token // the 'for' token
+	Variables []*Identifier
+	Iterable  Expression
+	Condition Expression // nil without an `if`
+}
+type YieldExpression struct {
+	Token token.Token // the 'yield' token
+	Value Expression
+}
+type SpawnExpression struct {
+	Token     token.Token // the 'spawn' token
+	Function  Expression
+	Arguments []Expression
+}
+type SelectExpression struct {
+	Token   token.Token // the 'select' token
+	Cases   []*SelectCase
+	Default *BlockStatement
+}
+type SelectCase struct {
+	Token    token.Token // the 'case' token
+	Variable *Identifier // bound to the received value, nil if unused
+	Send     bool
+	Channel  Expression
+	Value    Expression // the value to send, nil for receives
+	Body     *BlockStatement
+}
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -102,7 +191,18 @@ func (ls *LetStatement) TokenLiteral() string {
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Name != nil {
+		out.WriteString(ls.Name.String())
+	} else {
+		var names []string
+		for _, n := range ls.Names {
+			names = append(names, n.String())
+		}
+		out.WriteString("(" + strings.Join(names, ", ") + ")")
+	}
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -176,6 +276,14 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 func (ie *InfixExpression) expressionNode() {}
+
+func (pe *PipeExpression) TokenLiteral() string {
+	return pe.Token.Literal
+}
+func (pe *PipeExpression) String() string {
+	return "(" + pe.Left.String() + " |> " + pe.Right.String() + ")"
+}
+func (pe *PipeExpression) expressionNode() {}
 func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
@@ -223,17 +331,56 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	var params []string
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			params = append(params, p.String()+": "+fl.ParameterTypes[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 	out.WriteString(fl.TokenLiteral())
+	if fl.IsGenerator {
+		out.WriteString("*")
+	}
+	if fl.Name != "" {
+		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
+	out.WriteString(" ")
 	out.WriteString(fl.Body.String())
 	return out.String()
 }
 func (fl *FunctionLiteral) expressionNode() {}
+
+func (ta *TypeAnnotation) TokenLiteral() string {
+	return ta.Token.Literal
+}
+func (ta *TypeAnnotation) String() string {
+	var params []string
+	for _, p := range ta.Parameters {
+		params = append(params, p.String())
+	}
+	switch ta.Name {
+	case "array":
+		return "[" + params[0] + "]"
+	case "hash":
+		return "{" + params[0] + ": " + params[1] + "}"
+	case "set":
+		return "#{" + params[0] + "}"
+	case "fn":
+		out := "fn(" + strings.Join(params, ", ") + ")"
+		if ta.Return != nil {
+			out += ": " + ta.Return.String()
+		}
+		return out
+	}
+	return ta.Name
+}
 func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
@@ -277,6 +424,72 @@ func (a *ArrayLiteral) String() string {
 }
 func (a *ArrayLiteral) expressionNode() {}
 
+func (ac *ArrayComprehension) TokenLiteral() string {
+	return ac.Token.Literal
+}
+func (ac *ArrayComprehension) String() string {
+	return "[" + ac.Element.String() + " " + ac.Clause.String() + "]"
+}
+func (ac *ArrayComprehension) expressionNode() {}
+
+func (hc *HashComprehension) TokenLiteral() string {
+	return hc.Token.Literal
+}
+func (hc *HashComprehension) String() string {
+	return "{" + hc.Key.String() + ":" + hc.Value.String() + " " + hc.Clause.String() + "}"
+}
+func (hc *HashComprehension) expressionNode() {}
+
+func (cc *ComprehensionClause) String() string {
+	var out bytes.Buffer
+
+	var variables []string
+	for _, v := range cc.Variables {
+		variables = append(variables, v.String())
+	}
+	out.WriteString("for ")
+	out.WriteString(strings.Join(variables, ", "))
+	out.WriteString(" in ")
+	out.WriteString(cc.Iterable.String())
+	if cc.Condition != nil {
+		out.WriteString(" if ")
+		out.WriteString(cc.Condition.String())
+	}
+	return out.String()
+}
+
+func (sl *SetLiteral) TokenLiteral() string {
+	return sl.Token.Literal
+}
+func (sl *SetLiteral) String() string {
+	var out bytes.Buffer
+
+	var elements []string
+	for _, e := range sl.Elements {
+		elements = append(elements, e.String())
+	}
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+func (sl *SetLiteral) expressionNode() {}
+
+func (tl *TupleLiteral) TokenLiteral() string {
+	return tl.Token.Literal
+}
+func (tl *TupleLiteral) String() string {
+	var elements []string
+	for _, e := range tl.Elements {
+		elements = append(elements, e.String())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+func (tl *TupleLiteral) expressionNode() {}
+
 func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
@@ -295,6 +508,28 @@ func (*IndexExpression) expressionNode() {
 
 }
 
+func (se *SliceExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("]")
+	out.WriteString(")")
+	return out.String()
+}
+func (*SliceExpression) expressionNode() {}
+
 func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
@@ -302,8 +537,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -313,6 +548,144 @@ func (hl *HashLiteral) String() string {
 }
 func (*HashLiteral) expressionNode() {}
 
+func (ye *YieldExpression) TokenLiteral() string {
+	return ye.Token.Literal
+}
+func (ye *YieldExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ye.TokenLiteral() + " ")
+	if ye.Value != nil {
+		out.WriteString(ye.Value.String())
+	}
+	return out.String()
+}
+func (ye *YieldExpression) expressionNode() {}
+
+func (se *SpawnExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+func (se *SpawnExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{se.Function.String()}
+	for _, a := range se.Arguments {
+		args = append(args, a.String())
+	}
+	out.WriteString("spawn(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+	return out.String()
+}
+func (se *SpawnExpression) expressionNode() {}
+
+func (se *SelectExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+func (se *SelectExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("select { ")
+	for _, c := range se.Cases {
+		out.WriteString(c.String())
+		out.WriteString(" ")
+	}
+	if se.Default != nil {
+		out.WriteString("default { ")
+		out.WriteString(se.Default.String())
+		out.WriteString(" } ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+func (se *SelectExpression) expressionNode() {}
+
+func (sc *SelectCase) TokenLiteral() string {
+	return sc.Token.Literal
+}
+func (sc *SelectCase) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("case ")
+	if sc.Variable != nil {
+		out.WriteString(sc.Variable.String() + " = ")
+	}
+	if sc.Send {
+		out.WriteString("send(" + sc.Channel.String() + ", " + sc.Value.String() + ")")
+	} else {
+		out.WriteString("recv(" + sc.Channel.String() + ")")
+	}
+	out.WriteString(" { ")
+	out.WriteString(sc.Body.String())
+	out.WriteString(" }")
+	return out.String()
+}
+
+// EnumStatement declares `enum Name { Variant(field, ...), ... }`.
+type EnumStatement struct {
+	Token    token.Token // the 'enum' token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (es *EnumStatement) statementNode() {}
+func (es *EnumStatement) TokenLiteral() string {
+	return es.Token.Literal
+}
+func (es *EnumStatement) String() string {
+	var variants []string
+	for _, v := range es.Variants {
+		variants = append(variants, v.String())
+	}
+	return "enum " + es.Name.String() + " { " + strings.Join(variants, ", ") + " }"
+}
+func (ev *EnumVariant) String() string {
+	if len(ev.Fields) == 0 {
+		return ev.Name.String()
+	}
+	var fields []string
+	for _, f := range ev.Fields {
+		fields = append(fields, f.String())
+	}
+	return ev.Name.String() + "(" + strings.Join(fields, ", ") + ")"
+}
+
+// ImplStatement attaches the functions of a hash, keyed by operator or method
+// name, to a type tag: `impl Point { "+": fn(a, b) { ... } }`.
+type ImplStatement struct {
+	Token   token.Token // the 'impl' token
+	Name    *Identifier
+	Methods Expression
+}
+
+func (is *ImplStatement) statementNode() {}
+func (is *ImplStatement) TokenLiteral() string {
+	return is.Token.Literal
+}
+func (is *ImplStatement) String() string {
+	return "impl " + is.Name.String() + " " + is.Methods.String()
+}
+
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+func (fs *ForStatement) statementNode() {}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
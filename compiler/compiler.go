@@ -5,17 +5,16 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
 type Compiler struct {
-	instructions code.Instructions
-	constants    []object.Object
-
-	lastInstruction     EmittedInstruction
-	previousInstruction EmittedInstruction
+	constants []object.Object
 
 	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
 }
 
 type EmittedInstruction struct {
@@ -23,13 +22,29 @@ type EmittedInstruction struct {
 	Position int
 }
 
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
 func New() *Compiler {
-	return &Compiler{
+	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
-		constants:           []object.Object{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
-		symbolTable:         NewSymbolTable(),
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
 	}
 }
 
@@ -93,6 +108,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
+		case "..":
+			c.emit(code.OpRange)
+		case "in":
+			c.emit(code.OpIn)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
@@ -127,36 +146,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// Emit an `OpJumpNotTruthy` with a bogus value
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIsPop() {
-			c.removeLastPop()
-		}
-
 		// Emit an `OpJump` with a bogus value
 		jumpPos := c.emit(code.OpJump, 9999)
 
-		afterConsequencePop := len(c.instructions)
+		afterConsequencePop := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePop)
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compileBlockValue(node.Alternative)
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		}
-		afterAlternativePoc := len(c.instructions)
+		afterAlternativePoc := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePoc)
 
 	case *ast.BlockStatement:
+		c.enterBlock()
+		defer c.leaveBlock()
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -164,18 +178,46 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
+		if node.Names != nil {
+			return c.compileDestructuring(node)
+		}
+		if c.symbolTable.IsConst(node.Name.Value) {
+			return fmt.Errorf("cannot redeclare const %s", node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(code.OpSetGlobal, symbol.Index)
+		// defined after the value, which may refer to an outer binding of the
+		// same name; recursive functions resolve their own name instead
+		var symbol Symbol
+		if node.Token.Type == token.CONST {
+			symbol = c.symbolTable.DefineConst(node.Name.Value)
+		} else {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+		c.storeSymbol(symbol)
+	case *ast.EnumStatement:
+		for _, v := range node.Variants {
+			if c.symbolTable.IsConst(v.Name.Value) {
+				return fmt.Errorf("cannot redeclare const %s", v.Name.Value)
+			}
+			c.emit(code.OpConstant, c.addConstant(newVariant(node, v)))
+			c.storeSymbol(c.symbolTable.Define(v.Name.Value))
+		}
+	case *ast.ImplStatement:
+		err := c.Compile(node.Methods)
+		if err != nil {
+			return err
+		}
+		tag := &object.String{Value: node.Name.Value}
+		c.emit(code.OpImpl, c.addConstant(tag))
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
-		c.emit(code.OpGetGlobal, symbol.Index)
+		c.loadSymbol(symbol)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -187,15 +229,40 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(code.OpArray, len(node.Elements))
-	case *ast.HashLiteral:
-		var keys []ast.Expression
-		for k := range node.Pairs {
-			keys = append(keys, k)
+	case *ast.TupleLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-		for _, k := range keys {
+		c.emit(code.OpTuple, len(node.Elements))
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err := c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSet, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -216,8 +283,289 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		instructions := c.leaveScope()
+
+		for _, s := range freeSymbols {
+			c.loadSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			IsGenerator:   node.IsGenerator,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.PipeExpression:
+		callee := node.Right
+		var arguments []ast.Expression
+		if call, ok := node.Right.(*ast.CallExpression); ok {
+			callee = call.Function
+			arguments = call.Arguments
+		}
+
+		err := c.Compile(callee)
+		if err != nil {
+			return err
+		}
+		for _, a := range append([]ast.Expression{node.Left}, arguments...) {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(arguments)+1)
+	case *ast.YieldExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpYield)
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		c.enterBlock()
+		defer c.leaveBlock()
+
+		loopStart := len(c.currentInstructions())
+		// Emit an `OpIterNext` with a bogus value
+		iterNextPos := c.emit(code.OpIterNext, 9999)
+
+		symbol := c.symbolTable.Define(node.Variable.Value)
+		c.storeSymbol(symbol)
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+
+		afterLoop := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterLoop)
+	case *ast.ArrayComprehension:
+		c.emit(code.OpArray, 0)
+		return c.compileComprehension(node.Clause, func() error {
+			err := c.Compile(node.Element)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpAppend)
+			return nil
+		})
+	case *ast.HashComprehension:
+		c.emit(code.OpHash, 0)
+		return c.compileComprehension(node.Clause, func() error {
+			err := c.Compile(node.Key)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpInsert)
+			return nil
+		})
+	case *ast.SpawnExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSpawn, len(node.Arguments))
+	case *ast.SelectExpression:
+		return c.compileSelect(node)
+	}
+
+	return nil
+}
+
+// compileSelect pushes a channel, a value and a send flag for every case and
+// follows OpSelect with a jump table: the VM resumes at the entry of the case
+// it picked, with the received value (or null) on the stack.
+func (c *Compiler) compileSelect(node *ast.SelectExpression) error {
+	for _, sc := range node.Cases {
+		err := c.Compile(sc.Channel)
+		if err != nil {
+			return err
+		}
+		if sc.Send {
+			err = c.Compile(sc.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpNull)
+			c.emit(code.OpFalse)
+		}
+	}
+
+	hasDefault := 0
+	if node.Default != nil {
+		hasDefault = 1
+	}
+	c.emit(code.OpSelect, len(node.Cases), hasDefault)
+
+	var jumpTable []int
+	for i := 0; i < len(node.Cases)+hasDefault; i++ {
+		jumpTable = append(jumpTable, c.emit(code.OpJump, 9999))
+	}
+
+	var jumpsToEnd []int
+	for i, sc := range node.Cases {
+		c.changeOperand(jumpTable[i], len(c.currentInstructions()))
+		c.enterBlock()
+		if sc.Variable != nil {
+			symbol := c.symbolTable.Define(sc.Variable.Value)
+			c.storeSymbol(symbol)
+		} else {
+			c.emit(code.OpPop)
+		}
+		err := c.compileBlockValue(sc.Body)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+		jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
+	}
+	if node.Default != nil {
+		c.changeOperand(jumpTable[len(node.Cases)], len(c.currentInstructions()))
+		c.emit(code.OpPop)
+		err := c.compileBlockValue(node.Default)
+		if err != nil {
+			return err
+		}
+		jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
 	}
 
+	afterSelect := len(c.currentInstructions())
+	for _, pos := range jumpsToEnd {
+		c.changeOperand(pos, afterSelect)
+	}
+	return nil
+}
+
+// compileComprehension loops over the clause with the empty result
+// collection right below the iterator, where body adds to it with OpAppend or
+// OpInsert. The collection is left on the stack once the iterator runs out.
+func (c *Compiler) compileComprehension(clause *ast.ComprehensionClause, body func() error) error {
+	err := c.Compile(clause.Iterable)
+	if err != nil {
+		return err
+	}
+	if len(clause.Variables) == 2 {
+		c.emit(code.OpIterPairs)
+	} else {
+		c.emit(code.OpIter)
+	}
+
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	loopStart := len(c.currentInstructions())
+	// Emit an `OpIterNext` with a bogus value
+	iterNextPos := c.emit(code.OpIterNext, 9999)
+
+	if len(clause.Variables) == 2 {
+		c.emit(code.OpUnpack, 2)
+	}
+	var symbols []Symbol
+	for _, v := range clause.Variables {
+		symbols = append(symbols, c.symbolTable.Define(v.Value))
+	}
+	for i := len(symbols) - 1; i >= 0; i-- {
+		c.storeSymbol(symbols[i])
+	}
+
+	if clause.Condition != nil {
+		err := c.Compile(clause.Condition)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJumpNotTruthy, loopStart)
+	}
+
+	err = body()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loopStart)
+
+	afterLoop := len(c.currentInstructions())
+	c.changeOperand(iterNextPos, afterLoop)
+	return nil
+}
+
+// compileBlockValue compiles a block that has to leave exactly one value on
+// the stack, which is null unless it ends in an expression.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
 	return nil
 }
 
@@ -228,7 +576,7 @@ type Bytecode struct {
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
-		Instructions: c.instructions,
+		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
 	}
 }
@@ -247,39 +595,155 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	return pos
 }
 
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
-	posNewIns := len(c.instructions)
-	c.instructions = append(c.instructions, ins...)
+	posNewIns := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
 	return posNewIns
 }
 
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	previous := c.lastInstruction
+	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
 
-	c.previousInstruction = previous
-	c.lastInstruction = last
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
-func (c *Compiler) lastInstructionIsPop() bool {
-	return c.lastInstruction.Opcode == code.OpPop
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	oldIns := c.currentInstructions()
+	newIns := oldIns[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = newIns
+	c.scopes[c.scopeIndex].lastInstruction = previous
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
 	for i := 0; i < len(newInstruction); i++ {
-		c.instructions[pos+i] = newInstruction[i]
+		ins[pos+i] = newInstruction[i]
 	}
 }
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.instructions[opPos])
+	op := code.Opcode(c.currentInstructions()[opPos])
 
 	newInstruction := code.Make(op, operand)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// newVariant builds the value an enum variant's name is bound to.
+func newVariant(enum *ast.EnumStatement, v *ast.EnumVariant) object.Object {
+	var fields []string
+	for _, f := range v.Fields {
+		fields = append(fields, f.Value)
+	}
+	return object.NewVariant(enum.Name.Value, v.Name.Value, fields)
+}
+
+// compileDestructuring unpacks the value of `let (a, b) = ...` and stores its
+// elements, which OpUnpack leaves with the last one on top.
+func (c *Compiler) compileDestructuring(node *ast.LetStatement) error {
+	for _, name := range node.Names {
+		if c.symbolTable.IsConst(name.Value) {
+			return fmt.Errorf("cannot redeclare const %s", name.Value)
+		}
+	}
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpUnpack, len(node.Names))
+
+	var symbols []Symbol
+	for _, name := range node.Names {
+		if node.Token.Type == token.CONST {
+			symbols = append(symbols, c.symbolTable.DefineConst(name.Value))
+		} else {
+			symbols = append(symbols, c.symbolTable.Define(name.Value))
+		}
+	}
+	for i := len(symbols) - 1; i >= 0; i-- {
+		c.storeSymbol(symbols[i])
+	}
+	return nil
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
@@ -3,7 +3,11 @@ package compiler
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -13,23 +17,115 @@ type Symbol struct {
 }
 
 type SymbolTable struct {
+	Outer *SymbolTable
+
 	store          map[string]Symbol
+	consts         map[string]bool
 	numDefinitions int
+
+	// block tables scope the names of a `{}` body but take their slots from
+	// the enclosing function (or global) table
+	block bool
+
+	FreeSymbols []Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	c := make(map[string]bool)
+	free := []Symbol{}
+	return &SymbolTable{store: s, consts: c, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	owner := s
+	for owner.block {
+		owner = owner.Outer
+	}
+
+	symbol := Symbol{Name: name, Index: owner.numDefinitions}
+	if owner.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
 	s.store[name] = symbol
-	s.numDefinitions++
+	delete(s.consts, name)
+	owner.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	s.consts[name] = true
+	return symbol
+}
+
+// IsConst reports whether name is a const defined in this very table, which
+// inner blocks and functions are still free to shadow.
+func (s *SymbolTable) IsConst(name string) bool {
+	return s.consts[name]
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
 	return symbol
 }
 
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	symbol, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		symbol, ok = s.Outer.Resolve(name)
+		if !ok || s.block {
+			return symbol, ok
+		}
+		if symbol.Scope == BuiltinScope {
+			return symbol, ok
+		}
+		if symbol.Scope == GlobalScope && !s.Outer.inGlobalBlock(name) {
+			return symbol, ok
+		}
+		free := s.defineFree(symbol)
+		return free, true
+	}
 	return symbol, ok
 }
+
+// inGlobalBlock reports whether name is a global that was defined in a block.
+// Its slot is reused every time the block runs, e.g. by each turn of a loop,
+// so functions capture it by value like a local.
+func (s *SymbolTable) inGlobalBlock(name string) bool {
+	for table := s; table != nil; table = table.Outer {
+		if _, ok := table.store[name]; ok {
+			return table.block
+		}
+	}
+	return false
+}
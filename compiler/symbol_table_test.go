@@ -42,3 +42,129 @@ func TestResolveGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if len(secondLocal.FreeSymbols) != 1 || secondLocal.FreeSymbols[0].Name != "b" {
+		t.Errorf("wrong free symbols. got=%+v", secondLocal.FreeSymbols)
+	}
+}
+
+func TestResolveBlock(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	block := NewBlockSymbolTable(global)
+	block.Define("a")
+	block.Define("b")
+
+	local := NewEnclosedSymbolTable(block)
+	local.Define("c")
+	innerBlock := NewBlockSymbolTable(local)
+	innerBlock.Define("d")
+
+	tests := []struct {
+		table    *SymbolTable
+		expected []Symbol
+	}{
+		{global, []Symbol{{Name: "a", Scope: GlobalScope, Index: 0}}},
+		{block, []Symbol{
+			{Name: "a", Scope: GlobalScope, Index: 1},
+			{Name: "b", Scope: GlobalScope, Index: 2},
+		}},
+		{innerBlock, []Symbol{
+			{Name: "a", Scope: FreeScope, Index: 0},
+			{Name: "c", Scope: LocalScope, Index: 0},
+			{Name: "d", Scope: LocalScope, Index: 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expected {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+	}
+
+	expectedFree := Symbol{Name: "a", Scope: GlobalScope, Index: 1}
+	if len(local.FreeSymbols) != 1 || local.FreeSymbols[0] != expectedFree {
+		t.Errorf("a global from a block is not captured as a free symbol. got=%+v", local.FreeSymbols)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("block symbol b leaked into the global table")
+	}
+	if global.numDefinitions != 3 || local.numDefinitions != 2 {
+		t.Errorf("blocks did not take slots from their owner. got=%d, %d",
+			global.numDefinitions, local.numDefinitions)
+	}
+}
+
+func TestDefineConst(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineConst("a")
+	block := NewBlockSymbolTable(global)
+
+	if !global.IsConst("a") {
+		t.Errorf("a is not const in the table that defined it")
+	}
+	if block.IsConst("a") {
+		t.Errorf("a should be free to shadow in an inner block")
+	}
+}
+
+func TestDefineResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+
+	expected := []Symbol{
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+	}
+	for i, v := range expected {
+		global.DefineBuiltin(i, v.Name)
+	}
+
+	for _, table := range []*SymbolTable{global, local} {
+		for _, sym := range expected {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+	}
+}
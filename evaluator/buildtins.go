@@ -1,97 +1,64 @@
 package evaluator
 
 import (
-	"fmt"
 	"monkey/object"
 )
 
 var builtins = map[string]*object.Builtin{
-	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			}
-			return newError("argument to `len` not supported, got %s", args[0].Type())
-		},
-	},
-	"first": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.Array:
-				if len(arg.Elements) > 0 {
-					return arg.Elements[0]
-
-				}
-				return NULL
-			}
-			return newError("argument to `first` not supported, got %s", args[0].Type())
-		},
-	},
-	"last": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.Array:
-				if len(arg.Elements) > 0 {
-					return arg.Elements[len(arg.Elements)-1]
-
-				}
-				return NULL
-			}
-			return newError("argument to `last` not supported, got %s", args[0].Type())
-		},
-	},
-	"rest": { // TODO testcases
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.Array:
-				length := len(arg.Elements)
-				if length > 0 {
-					newElements := make([]object.Object, length-1)
-					copy(newElements, arg.Elements[1:length])
-					return &object.Array{Elements: newElements}
-				}
-				return NULL
-			}
-			return newError("argument to `rest` not supported, got %s", args[0].Type())
-		},
-	},
-	"push": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.Array:
-				length := len(arg.Elements)
-				newElements := make([]object.Object, length+1)
-				copy(newElements, arg.Elements)
-				newElements[length] = args[1]
-				return &object.Array{Elements: newElements}
-			}
-			return newError("argument to `push` not supported, got %s", args[0].Type())
-		},
-	},
-	"puts": {
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-			return NULL
-		},
-	},
+	"len":          object.GetBuiltinByName("len"),
+	"puts":         object.GetBuiltinByName("puts"),
+	"first":        object.GetBuiltinByName("first"),
+	"last":         object.GetBuiltinByName("last"),
+	"rest":         object.GetBuiltinByName("rest"),
+	"push":         object.GetBuiltinByName("push"),
+	"next":         object.GetBuiltinByName("next"),
+	"channel":      object.GetBuiltinByName("channel"),
+	"send":         object.GetBuiltinByName("send"),
+	"recv":         object.GetBuiltinByName("recv"),
+	"close":        object.GetBuiltinByName("close"),
+	"set":          object.GetBuiltinByName("set"),
+	"union":        object.GetBuiltinByName("union"),
+	"intersection": object.GetBuiltinByName("intersection"),
+	"difference":   object.GetBuiltinByName("difference"),
+	"subset":       object.GetBuiltinByName("subset"),
+	"has":          object.GetBuiltinByName("has"),
+	"tag":          object.GetBuiltinByName("tag"),
+	"new":          object.GetBuiltinByName("new"),
+	"to_string":    object.GetBuiltinByName("to_string"),
+	"split":        object.GetBuiltinByName("split"),
+	"join":         object.GetBuiltinByName("join"),
+	"trim":         object.GetBuiltinByName("trim"),
+	"upper":        object.GetBuiltinByName("upper"),
+	"lower":        object.GetBuiltinByName("lower"),
+	"replace":      object.GetBuiltinByName("replace"),
+	"contains":     object.GetBuiltinByName("contains"),
+	"starts_with":  object.GetBuiltinByName("starts_with"),
+	"ends_with":    object.GetBuiltinByName("ends_with"),
+	"index_of":     object.GetBuiltinByName("index_of"),
+	"repeat":       object.GetBuiltinByName("repeat"),
+	"pad_left":     object.GetBuiltinByName("pad_left"),
+	"pad_right":    object.GetBuiltinByName("pad_right"),
+	"map":          object.GetBuiltinByName("map"),
+	"filter":       object.GetBuiltinByName("filter"),
+	"reduce":       object.GetBuiltinByName("reduce"),
+	"each":         object.GetBuiltinByName("each"),
+	"any":          object.GetBuiltinByName("any"),
+	"all":          object.GetBuiltinByName("all"),
+	"find":         object.GetBuiltinByName("find"),
+	"zip":          object.GetBuiltinByName("zip"),
+	"flat_map":     object.GetBuiltinByName("flat_map"),
+	"keys":         object.GetBuiltinByName("keys"),
+	"values":       object.GetBuiltinByName("values"),
+	"entries":      object.GetBuiltinByName("entries"),
+	"delete":       object.GetBuiltinByName("delete"),
+	"merge":        object.GetBuiltinByName("merge"),
+	"chars":        object.GetBuiltinByName("chars"),
+	"append!":      object.GetBuiltinByName("append!"),
+	"pop!":         object.GetBuiltinByName("pop!"),
+	"insert!":      object.GetBuiltinByName("insert!"),
+	"remove!":      object.GetBuiltinByName("remove!"),
+	"reverse!":     object.GetBuiltinByName("reverse!"),
+	"sort!":        object.GetBuiltinByName("sort!"),
+	"sort":         object.GetBuiltinByName("sort"),
+	"sort_by":      object.GetBuiltinByName("sort_by"),
 }
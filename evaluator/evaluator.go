@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
+	goruntime "runtime"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -37,11 +39,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		if node.Operator == "in" {
+			return evalInExpression(left, right)
+		}
+		return evalInfixExpression(node.Operator, left, right, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node.Statements, env)
+		return evalBlockStatement(node.Statements, object.NewEnclosedEnvironment(env))
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -49,17 +54,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
+		if node.Names != nil {
+			return evalDestructuring(node, env)
+		}
+		if env.IsConst(node.Name.Value) {
+			return newError("cannot redeclare const %s", node.Name.Value)
+		}
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if node.Token.Type == token.CONST {
+			env.SetConst(node.Name.Value, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
+	case *ast.EnumStatement:
+		return evalEnumStatement(node, env)
+	case *ast.ImplStatement:
+		return evalImplStatement(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Parameters: params, Body: body, Env: env, IsGenerator: node.IsGenerator}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -69,7 +88,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, env)
+	case *ast.PipeExpression:
+		return evalPipeExpression(node, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
@@ -78,6 +99,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
+	case *ast.SetLiteral:
+		return evalSetLiteral(node, env)
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Tuple{Elements: elements}
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -90,36 +121,428 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.YieldExpression:
+		return evalYieldExpression(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.ArrayComprehension:
+		return evalArrayComprehension(node, env)
+	case *ast.HashComprehension:
+		return evalHashComprehension(node, env)
+	case *ast.SpawnExpression:
+		return evalSpawnExpression(node, env)
+	case *ast.SelectExpression:
+		return evalSelectExpression(node, env)
 	}
 	return nil
 }
 
+// evalPipeExpression evaluates the function before the piped value, the same
+// order the compiled code uses.
+func evalPipeExpression(node *ast.PipeExpression, env *object.Environment) object.Object {
+	callee := node.Right
+	var arguments []ast.Expression
+	if call, ok := node.Right.(*ast.CallExpression); ok {
+		callee = call.Function
+		arguments = call.Arguments
+	}
+
+	function := Eval(callee, env)
+	if isError(function) {
+		return function
+	}
+	args := evalExpressions(append([]ast.Expression{node.Left}, arguments...), env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	return applyFunction(function, args, env)
+}
+
+// evalSpawnExpression calls the function on a new goroutine. The returned
+// channel receives the function's result once it finishes and is then closed.
+func evalSpawnExpression(node *ast.SpawnExpression, env *object.Environment) object.Object {
+	function := Eval(node.Function, env)
+	if isError(function) {
+		return function
+	}
+	switch function.(type) {
+	case *object.Function, *object.Builtin:
+	default:
+		return newError("cannot spawn %s", function.Type())
+	}
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	result := object.NewChannel(1)
+	go func() {
+		_ = result.Send(applyFunction(function, args, env))
+		_ = result.Close()
+	}()
+	return result
+}
+
+func evalSelectExpression(node *ast.SelectExpression, env *object.Environment) object.Object {
+	cases := make([]object.SelectCase, len(node.Cases))
+	for i, sc := range node.Cases {
+		channel := Eval(sc.Channel, env)
+		if isError(channel) {
+			return channel
+		}
+		ch, ok := channel.(*object.Channel)
+		if !ok {
+			return newError("select case is not a channel: %s", channel.Type())
+		}
+		cases[i].Channel = ch
+		if sc.Send {
+			value := Eval(sc.Value, env)
+			if isError(value) {
+				return value
+			}
+			cases[i].Value = value
+		}
+	}
+
+	chosen, received, err := object.Select(cases, node.Default != nil)
+	if err != nil {
+		return newError("%s", err)
+	}
+	if chosen == len(node.Cases) {
+		return evalSelectBody(node.Default, env)
+	}
+
+	sc := node.Cases[chosen]
+	caseEnv := object.NewEnclosedEnvironment(env)
+	if sc.Variable != nil {
+		if received == nil {
+			received = NULL
+		}
+		caseEnv.Set(sc.Variable.Value, received)
+	}
+	return evalSelectBody(sc.Body, caseEnv)
+}
+
+func evalSelectBody(body *ast.BlockStatement, env *object.Environment) object.Object {
+	result := Eval(body, env)
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func evalYieldExpression(node *ast.YieldExpression, env *object.Environment) object.Object {
+	yield, ok := env.Yield()
+	if !ok {
+		return newError("yield outside of generator function")
+	}
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+	yield(value)
+	return NULL
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	it, ok := iterable.(object.Iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	iterator := it.Iterator()
+	for {
+		value, ok := iterator.Next()
+		if !ok {
+			return nil
+		}
+		if isError(value) {
+			return value
+		}
+		loopEnv := object.NewEnclosedEnvironment(env)
+		loopEnv.Set(node.Variable.Value, value)
+		result := Eval(node.Body, loopEnv)
+		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+			return result
+		}
+	}
+}
+
+func evalArrayComprehension(node *ast.ArrayComprehension, env *object.Environment) object.Object {
+	elements := []object.Object{}
+	err := evalComprehension(node.Clause, env, func(loopEnv *object.Environment) object.Object {
+		el := Eval(node.Element, loopEnv)
+		if isError(el) {
+			return el
+		}
+		elements = append(elements, el)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Array{Elements: elements}
+}
+
+func evalHashComprehension(node *ast.HashComprehension, env *object.Environment) object.Object {
+	hash := object.NewHash()
+	err := evalComprehension(node.Clause, env, func(loopEnv *object.Environment) object.Object {
+		key := Eval(node.Key, loopEnv)
+		if isError(key) {
+			return key
+		}
+		if err := object.KeyError("hash key", key); err != nil {
+			return newError("%s", err)
+		}
+		value := Eval(node.Value, loopEnv)
+		if isError(value) {
+			return value
+		}
+		hash.Put(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return hash
+}
+
+// evalComprehension calls body for every element the clause lets through,
+// each time in a fresh environment holding the loop variables. It returns
+// the first error, if any.
+func evalComprehension(clause *ast.ComprehensionClause, env *object.Environment, body func(*object.Environment) object.Object) object.Object {
+	iterable := Eval(clause.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	var iterator object.Iterator
+	if len(clause.Variables) == 2 {
+		pairs, ok := object.Pairs(iterable)
+		if !ok {
+			return newError("cannot iterate over key/value pairs of %s", iterable.Type())
+		}
+		iterator = pairs
+	} else {
+		it, ok := iterable.(object.Iterable)
+		if !ok {
+			return newError("cannot iterate over %s", iterable.Type())
+		}
+		iterator = it.Iterator()
+	}
+
+	for {
+		value, ok := iterator.Next()
+		if !ok {
+			return nil
+		}
+		if isError(value) {
+			return value
+		}
+		loopEnv := object.NewEnclosedEnvironment(env)
+		if len(clause.Variables) == 2 {
+			pair := value.(*object.Array)
+			loopEnv.Set(clause.Variables[0].Value, pair.Elements[0])
+			loopEnv.Set(clause.Variables[1].Value, pair.Elements[1])
+		} else {
+			loopEnv.Set(clause.Variables[0].Value, value)
+		}
+
+		if clause.Condition != nil {
+			condition := Eval(clause.Condition, loopEnv)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				continue
+			}
+		}
+		if result := body(loopEnv); isError(result) {
+			return result
+		}
+	}
+}
+
+// newGenerator runs the body of fn on its own goroutine, handing control back
+// and forth over unbuffered channels so that only one side runs at a time.
+// The body does not start until the first value is requested. Once nothing
+// refers to the generator any more, done is closed and a body still waiting
+// to hand over or resume ends its goroutine, so abandoned generators do not
+// leak.
+func newGenerator(fn *object.Function, args []object.Object) *object.Generator {
+	yields := make(chan object.Object)
+	resume := make(chan struct{})
+	done := make(chan struct{})
+	started := false
+
+	send := func(value object.Object) {
+		select {
+		case yields <- value:
+		case <-done:
+			goruntime.Goexit()
+		}
+	}
+	run := func() {
+		yield := func(value object.Object) {
+			send(value)
+			select {
+			case <-resume:
+			case <-done:
+				goruntime.Goexit()
+			}
+		}
+		env := object.NewGeneratorEnvironment(fn.Env, yield)
+		for i, p := range fn.Parameters {
+			env.Set(p.Value, args[i])
+		}
+		result := Eval(fn.Body, env)
+		if isError(result) {
+			send(result)
+		}
+		close(yields)
+	}
+
+	gen := &object.Generator{Resume: func() (object.Object, bool) {
+		if started {
+			resume <- struct{}{}
+		} else {
+			started = true
+			go run()
+		}
+		value, ok := <-yields
+		return value, ok
+	}}
+	goruntime.SetFinalizer(gen, func(*object.Generator) { close(done) })
+	return gen
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+		if err := object.KeyError("hash key", key); err != nil {
+			return newError("%s", err)
 		}
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Put(key, value)
+	}
+	return hash
+}
+
+func evalSetLiteral(node *ast.SetLiteral, env *object.Environment) object.Object {
+	elements := evalExpressions(node.Elements, env)
+	if len(elements) == 1 && isError(elements[0]) {
+		return elements[0]
+	}
+	set := object.NewSet()
+	for _, el := range elements {
+		if !set.Add(el) {
+			return newError("%s", object.KeyError("set element", el))
+		}
+	}
+	return set
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	var bounds [2]object.Object
+	for i, bound := range []ast.Expression{node.Start, node.End} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	result, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
+func evalEnumStatement(node *ast.EnumStatement, env *object.Environment) object.Object {
+	for _, v := range node.Variants {
+		if env.IsConst(v.Name.Value) {
+			return newError("cannot redeclare const %s", v.Name.Value)
+		}
+		var fields []string
+		for _, f := range v.Fields {
+			fields = append(fields, f.Value)
+		}
+		env.Set(v.Name.Value, object.NewVariant(node.Name.Value, v.Name.Value, fields))
+	}
+	return nil
+}
+
+func evalDestructuring(node *ast.LetStatement, env *object.Environment) object.Object {
+	for _, name := range node.Names {
+		if env.IsConst(name.Value) {
+			return newError("cannot redeclare const %s", name.Value)
+		}
+	}
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	elements, err := object.Unpack(val, len(node.Names))
+	if err != nil {
+		return newError("%s", err)
+	}
+	for i, name := range node.Names {
+		if node.Token.Type == token.CONST {
+			env.SetConst(name.Value, elements[i])
+		} else {
+			env.Set(name.Value, elements[i])
+		}
+	}
+	return nil
+}
+
+func evalImplStatement(node *ast.ImplStatement, env *object.Environment) object.Object {
+	methods := Eval(node.Methods, env)
+	if isError(methods) {
+		return methods
+	}
+	err := env.Methods().Impl(node.Name.Value, methods.(*object.Hash))
+	if err != nil {
+		return newError("%s", err)
 	}
-	return &object.Hash{Pairs: pairs}
+	return nil
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
+	case left.Type() == object.VARIANT_OBJ:
+		val, err := left.(*object.Variant).Index(index)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return val
+	case left.Type() == object.TUPLE_OBJ:
+		val, err := left.(*object.Tuple).Index(index)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return val
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	}
@@ -129,39 +552,85 @@ func evalIndexExpression(left, index object.Object) object.Object {
 func evalHashIndexExpression(hash object.Object, index object.Object) object.Object {
 	hashObject, ok := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	if err := object.KeyError("hash key", index); err != nil {
+		return newError("%s", err)
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(index)
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
 
 func evalArrayIndexExpression(left, index object.Object) object.Object {
 	arrayObject := left.(*object.Array)
 	idx := index.(*object.Integer).Value
 	maxIdx := int64(len(arrayObject.Elements) - 1)
+	if idx < 0 {
+		idx += maxIdx + 1
+	}
 	if idx < 0 || idx > maxIdx {
 		return NULL
 	}
 	return arrayObject.Elements[idx]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	maxIdx := int64(len(runes) - 1)
+	if idx < 0 {
+		idx += maxIdx + 1
+	}
+	if idx < 0 || idx > maxIdx {
+		return NULL
+	}
+	return &object.String{Value: string(runes[idx])}
+}
+
+// Apply calls fn with args the way a call expression would, for hosts that
+// invoke Monkey callbacks from the outside.
+func Apply(fn object.Object, args ...object.Object) object.Object {
+	env := object.NewEnvironment()
+	if fn, ok := fn.(*object.Function); ok {
+		env = fn.Env
+	}
+	return applyFunction(fn, args, env)
+}
+
+// applyFunction calls fn from env. Builtins are handed a runtime for env so
+// they can call back into the program.
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if fn.IsGenerator {
+			return newGenerator(fn, args)
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Call(runtime{env: env}, args...); result != nil {
+			return result
+		}
+		return NULL
 	}
 	return newError("not a function: %s", fn.Type())
 }
 
+// runtime is the object.Runtime of builtins called from env.
+type runtime struct {
+	env *object.Environment
+}
+
+func (r runtime) Call(fn object.Object, args ...object.Object) object.Object {
+	return applyFunction(fn, args, r.env)
+}
+
+func (r runtime) Method(obj object.Object, name string) (object.Object, bool) {
+	return r.env.Methods().Lookup(obj, name)
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -227,23 +696,55 @@ func isTruthy(condition object.Object) bool {
 	}
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func evalInfixExpression(operator string, left object.Object, right object.Object, env *object.Environment) object.Object {
+	if result, ok := evalOperatorMethod(operator, left, right, env); ok {
+		return result
+	}
 	switch {
+	case operator == "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
-	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
-	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// evalOperatorMethod applies the impl of operator for the type of left or,
+// failing that, of right. != negates an implemented ==.
+func evalOperatorMethod(operator string, left, right object.Object, env *object.Environment) (object.Object, bool) {
+	name := operator
+	if operator == "!=" {
+		name = "=="
+	}
+	fn, ok := env.Methods().Lookup(left, name)
+	if !ok {
+		fn, ok = env.Methods().Lookup(right, name)
+	}
+	if !ok {
+		return nil, false
+	}
+	result := applyFunction(fn, []object.Object{left, right}, env)
+	if operator == "!=" && !isError(result) {
+		return nativeBoolToBooleanObject(!isTruthy(result)), true
+	}
+	return result, true
+}
+
+func evalInExpression(element, container object.Object) object.Object {
+	found, err := object.Contains(container, element)
+	if err != nil {
+		return newError("%s", err)
+	}
+	return nativeBoolToBooleanObject(found)
+}
+
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
@@ -251,6 +752,14 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 	switch operator {
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -277,6 +786,8 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "..":
+		return &object.Range{Start: leftVal, End: rightVal}
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
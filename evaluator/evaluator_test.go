@@ -5,7 +5,9 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	goruntime "runtime"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -62,6 +64,20 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`#{1, 2} == #{2, 1}`, true},
+		{`"ab" == "a" + "b"`, true},
+		{`1..3 == 1..3`, true},
+		{`1 == "1"`, false},
+		{`[1] != {"a": 1}`, true},
+		{`true == 1`, false},
+		{`new("P", {"x": 1}) == {"x": 1}`, false},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%s", tt.input), func(t *testing.T) {
@@ -170,6 +186,43 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestBlockScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 1; if (true) { let a = 2; }; a;", 1},
+		{"let a = 1; if (true) { let a = a + 1; a }", 2},
+		{"let a = 1; let a = 2; a;", 2},
+		{"if (true) { let b = 2; }; b;", "identifier not found: b"},
+		{"const a = 1; a;", 1},
+		{"const a = 1; if (true) { let a = 2; a }", 2},
+		{"const a = 1; if (true) { const a = 2; }; a;", 1},
+		{"let f = fn(a) { const a = 2; a }; f(1);", 2},
+		{"const a = 1; let a = 2;", "cannot redeclare const a"},
+		{"const a = 1; const a = 2;", "cannot redeclare const a"},
+		{"let a = 1; const a = 2; let a = 3;", "cannot redeclare const a"},
+		{"let f = fn() { const a = 1; let a = 2; }; f();", "cannot redeclare const a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+				}
+			}
+		})
+	}
+}
+
 func testNullObjects(t *testing.T, e object.Object) bool {
 	if e != NULL {
 		t.Errorf("object is not NULL. Got %T (%+v)", e, e)
@@ -286,6 +339,29 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestStringIndexingAndComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a" == "a"`, "true"},
+		{`"a" == "b"`, "false"},
+		{`"a" != "b"`, "true"},
+		{`"apple" < "banana"`, "true"},
+		{`"apple" > "banana"`, "false"},
+		{`"b" > "abc"`, "true"},
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[-1]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "monkey"; s[len(s) - 1]`, "y"},
+		{`"monkey"[6]`, "null"},
+		{`""[0]`, "null"},
+		{`let g = fn*(s) { for (c in s) { yield c + c } }; let it = g("ab"); next(it) + next(it)`, "aabb"},
+		{`"a" - "b"`, "Error: unknown operator: STRING - STRING"},
+	}
+	testInspections(t, tests)
+}
+
 func TestBuiltinFunction(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -295,6 +371,7 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("four two")`, 8},
+		{`len("héllo")`, 5},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("1", "2")`, "wrong number of arguments. got=2, want=1"},
 		// Array
@@ -356,6 +433,324 @@ func TestArrayLiteral(t *testing.T) {
 	testIntegerObject(t, a.Elements[2], 9)
 }
 
+func TestSlicesAndRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`[1, 2, 3, 4][:-1]`, "[1, 2, 3]"},
+		{`[1, 2, 3, 4][-2:]`, "[3, 4]"},
+		{`[1, 2, 3, 4][:]`, "[1, 2, 3, 4]"},
+		{`[1, 2, 3, 4][3:1]`, "[]"},
+		{`[1, 2, 3, 4][-10:10]`, "[1, 2, 3, 4]"},
+		{`"hello"[2:]`, "llo"},
+		{`"héllo"[1:3]`, "él"},
+		{`1..10`, "1..10"},
+		{`1 + 1..2 * 5`, "2..10"},
+		{`len(0..10)`, "10"},
+		{`len(5..1)`, "0"},
+		{`let g = fn*() { for (i in 3..6) { yield i } }; let it = g(); next(it); next(it)`, "4"},
+		{`[1][true:]`, "Error: slice bounds must be INTEGER, got BOOLEAN"},
+		{`5[1:]`, "Error: slice operator not supported: INTEGER"},
+		{`1.."a"`, "Error: type mismatch: INTEGER .. STRING"},
+	}
+	testInspections(t, tests)
+}
+
+func TestEnums(t *testing.T) {
+	enum := `enum Shape { Circle(r), Rect(w, h), Empty };`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{enum + `Rect(3, 4)`, "Rect(3, 4)"},
+		{enum + `[Empty, Circle("r")]`, `[Empty, Circle(r)]`},
+		{enum + `Circle(2) == Circle(2)`, "true"},
+		{enum + `Circle(2) == Circle(3)`, "false"},
+		{enum + `Circle(2) != Rect(2, 2)`, "true"},
+		{enum + `let area = fn(s) { if (tag(s) == "Rect") { s["w"] * s["h"] } else { 0 } }; area(Rect(3, 4)) + area(Circle(1))`, "12"},
+		{enum + `Rect(3, 4)[1]`, "4"},
+		{enum + `let h = {Circle(1): "one", Empty: "none"}; h[Circle(1)] + h[Empty]`, "onenone"},
+		{enum + `Circle(1) in [Empty, Circle(1)]`, "true"},
+		{`let f = fn() { enum State { On, Off } [On, Off] }; f()[0] == f()[0]`, "true"},
+		{enum + `Rect(1)`, "Error: wrong number of arguments to `Rect`. got=1, want=2"},
+		{enum + `Circle(1)["w"]`, "Error: Circle has no field w"},
+		{enum + `Circle(1) > Empty`, "Error: unknown operator: VARIANT > VARIANT"},
+		{enum + `Circle(1) == 1`, "false"},
+		{`tag(1)`, "Error: argument to `tag` must be VARIANT, got INTEGER"},
+		{`const On = 1; enum State { On }`, "Error: cannot redeclare const On"},
+	}
+	testInspections(t, tests)
+}
+
+func TestTuples(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(1, "a", [2])`, "(1, a, [2])"},
+		{`(1,)`, "(1,)"},
+		{`()`, "()"},
+		{`(1, 2)[-1]`, "2"},
+		{`(1, "a") == (1, "a")`, "true"},
+		{`{(1, 2): "a"}[(1, 2)]`, "a"},
+		{`let divmod = fn(a, b) { return a / b, a - (a / b) * b }; divmod(17, 5)`, "(3, 2)"},
+		{`let (q, r) = (3, 2); q * 10 + r`, "32"},
+		{`const (a, b) = (1, 2); let a = 3`, "Error: cannot redeclare const a"},
+		{`let (a, b) = (1, 2, 3)`, "Error: cannot unpack (1, 2, 3) into 2 values"},
+		{`(1, 2)[true]`, "Error: unusable as tuple index: BOOLEAN"},
+	}
+	testInspections(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`"a,b" |> split(",") |> join(" and ")`, "a and b"},
+		{`trim(" x ") + upper("y") + lower("Z")`, "xYz"},
+		{`replace("a-b-c", "-", "")`, "abc"},
+		{`[contains("abc", "b"), starts_with("abc", "a"), ends_with("abc", "a")]`, "[true, true, false]"},
+		{`index_of("día", "a")`, "2"},
+		{`repeat("-", 3)`, "---"},
+		{`pad_right("ab", 4, ".")`, "ab.."},
+		{`chars("ab")`, "[a, b]"},
+		{`chars("")`, "[]"},
+		{`lower()`, "Error: wrong number of arguments. got=0, want=1"},
+		{`contains("a", 1)`, "Error: second argument to `contains` must be STRING, got INTEGER"},
+		{`repeat("ab", 9223372036854775807)`, "Error: result of `repeat` would be longer than 1073741824 bytes"},
+		{`repeat("", 9223372036854775807)`, ""},
+		{`pad_left("a", 9223372036854775807)`, "Error: result of `pad_left` would be longer than 1073741824 bytes"},
+	}
+	testInspections(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 2, "a": 1, "c": 3}`, "{b: 2, a: 1, c: 3}"},
+		{`let h = {"b": 2, "a": 1}; merge(h, {"c": 3, "b": 4})`, "{b: 4, a: 1, c: 3}"},
+		{`delete({"b": 2, "a": 1, "c": 3}, "a")`, "{b: 2, c: 3}"},
+		{`{k: v for k, v in {"z": 1, "y": 2, "x": 3}}`, "{z: 1, y: 2, x: 3}"},
+		{`[k for k in {"z": 1, "y": 2, "x": 3}]`, "[z, y, x]"},
+		{`let grid = {[0, 0]: "origin", [1, 2]: "p"}; grid[[1, 2]]`, "p"},
+		{`let seen = {{"b": 2, "a": 1}: true}; seen[{"a": 1, "b": 2}]`, "true"},
+		{`{[1, [puts]]: 1}`, "Error: unusable as hash key: ARRAY containing BUILTIN"},
+		{`keys({"b": 2, "a": 1, true: 3, 0: 4})`, "[b, a, true, 0]"},
+		{`values({"b": 2, "a": 1})`, "[2, 1]"},
+		{`entries({"b": 2, "a": 1})`, "[(b, 2), (a, 1)]"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`delete({"a": 1, "b": 2}, "a")`, "{b: 2}"},
+		{`merge({"a": 1}, {"a": 2})`, "{a: 2}"},
+		{`len({"a": 1})`, "1"},
+		{`delete([], 1)`, "Error: first argument to `delete` must be HASH, got ARRAY"},
+		{`values({}, {})`, "Error: wrong number of arguments. got=2, want=1"},
+	}
+	testInspections(t, tests)
+}
+
+func TestArrayMutation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; append!(a, 2); a`, "[1, 2]"},
+		{`let a = [1, 2, 3]; [pop!(a), a]`, "[3, [1, 2]]"},
+		{`pop!([])`, "null"},
+		{`let a = [1, 3]; insert!(a, 1, 2); insert!(a, 3, 4); insert!(a, -5, 0)`, "[0, 1, 2, 3, 4]"},
+		{`let a = ["a", "b", "c"]; [remove!(a, 1), remove!(a, -1), a]`, "[b, c, [a]]"},
+		{`reverse!([1, 2, 3])`, "[3, 2, 1]"},
+		{`sort!([3, 1, 2])`, "[1, 2, 3]"},
+		{`sort!(["b", "c", "a"])`, "[a, b, c]"},
+		{`let a = [1]; let b = a; append!(b, 2); a`, "[1, 2]"},
+		{`let a = [1, 2]; let b = push(a, 3); let c = rest(a); reverse!(a); [a, b, c]`, "[[2, 1], [1, 2, 3], [2]]"},
+		{`let a = push([1], 2); let b = push(a, 3); append!(a, 4); pop!(b); append!(b, 5); [a, b]`, "[[1, 2, 4], [1, 2, 5]]"},
+		{`let a = [2, 1]; let b = rest(a); sort!(b); [a, b]`, "[[2, 1], [1]]"},
+		{`insert!([1], 3, 0)`, "Error: index 3 out of range for `insert!`"},
+		{`remove!([], 0)`, "Error: index 0 out of range for `remove!`"},
+		{`let a = [2, "a", 1]; [sort!(a), a]`, "Error: cannot compare STRING with INTEGER"},
+		{`let k = [1]; let h = {k: "v"}; append!(k, 2); [h, h[[1]], k in h, h[k]]`, "[{[1]: v}, v, false, null]"},
+		{`let k = [1]; let s = #{k, (k, 2)}; sort!(append!(k, 0)); [has(s, [1]), has(s, ([1], 2)), has(s, k)]`, "[true, true, false]"},
+		{`let k = [1]; let h = {{"a": k}: 1}; append!(k, 2); h[{"a": [1]}]`, "1"},
+		{`let h = {[2, 1]: 1}; sort!(keys(h)[0])`, "Error: `sort!` cannot change an array used as a hash key or set element"},
+		{`let k = keys({[1]: 0})[0]; [push(k, 2), rest(k)]`, "[[1, 2], []]"},
+		{`append!(1, 2)`, "Error: first argument to `append!` must be ARRAY, got INTEGER"},
+		{`reverse!("ab")`, "Error: argument to `reverse!` must be ARRAY, got STRING"},
+	}
+	testInspections(t, tests)
+}
+
+func TestSortBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`sort([3, -1, 2, 0])`, "[-1, 0, 2, 3]"},
+		{`sort(["pear", "apple", "fig"])`, "[apple, fig, pear]"},
+		{`sort([])`, "[]"},
+		{`let a = [2, 1]; let b = sort(a); [a, b]`, "[[2, 1], [1, 2]]"},
+		{`sort([1, 3, 2], fn(a, b) { b - a })`, "[3, 2, 1]"},
+		{`sort(["bb", "a", "cc", "b"], fn(a, b) { len(a) - len(b) })`, "[a, b, bb, cc]"},
+		{`sort_by(["ccc", "a", "bb", "d"], len)`, "[a, d, bb, ccc]"},
+		{`sort_by([(2, "x"), (1, "y"), (2, "a")], fn(t) { t[0] })`, "[(1, y), (2, x), (2, a)]"},
+		{`let a = [1, 3, 2]; sort!(a, fn(x, y) { y - x }); a`, "[3, 2, 1]"},
+		{`sort([2, "a"])`, "Error: cannot compare STRING with INTEGER"},
+		{`sort([[1], [2]])`, "Error: cannot compare ARRAY with ARRAY"},
+		{`sort_by([1, 2], fn(x) { if (x > 1) { "a" } else { x } })`, "Error: cannot compare STRING with INTEGER"},
+		{`sort([1, 2], fn(a, b) { a < b })`, "Error: comparison function for `sort` must return INTEGER, got BOOLEAN"},
+		{`sort([1, 2], fn(a, b) { a + "" })`, "Error: type mismatch: INTEGER + STRING"},
+		{`sort(1)`, "Error: argument to `sort` must be ARRAY, got INTEGER"},
+		{`sort([1], 2)`, "Error: second argument to `sort` must be a function, got INTEGER"},
+		{`sort_by([1])`, "Error: wrong number of arguments. got=1, want=2"},
+	}
+	testInspections(t, tests)
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`"abc" |> map(upper)`, "[A, B, C]"},
+		{`filter(0..6, fn(x) { x > 3 })`, "[4, 5]"},
+		{`reduce(["a", "b"], "", fn(acc, s) { acc + s })`, "ab"},
+		{`each([1], fn(x) { x })`, "null"},
+		{`[any([1, 2], fn(x) { x == 2 }), all([1, 2], fn(x) { x > 0 })]`, "[true, true]"},
+		{`find(["a", "bb"], fn(s) { len(s) == 2 })`, "bb"},
+		{`zip([1, 2], ["a", "b"], [true])`, "[(1, a, true)]"},
+		{`flat_map(["ab", "c"], chars)`, "[a, b, c]"},
+		{`map([1], fn(x) { y })`, "Error: identifier not found: y"},
+		{`any([1], 2)`, "Error: second argument to `any` must be a function, got INTEGER"},
+	}
+	testInspections(t, tests)
+}
+
+func TestImpls(t *testing.T) {
+	point := `impl Point {
+		"+": fn(a, b) { new("Point", {"x": a["x"] + b["x"]}) },
+		"==": fn(a, b) { a["x"] == b["x"] },
+		"to_string": fn(p) { "Point(" + to_string(p["x"]) + ")" },
+		"len": fn(p) { p["x"] }
+	};
+	let p = fn(x) { new("Point", {"x": x}) };`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{point + `p(1) + p(2)`, `Point {x: 3}`},
+		{point + `p(1) == p(1)`, "true"},
+		{point + `p(1) != p(1)`, "false"},
+		{point + `len(p(4))`, "4"},
+		{point + `to_string(p(4))`, "Point(4)"},
+		{point + `p(1) - p(2)`, "Error: unknown operator: HASH - HASH"},
+		{point + `let q = fn() { p(5) }; len(q())`, "5"},
+		{`enum Money { Cents(n) }; impl Money { "+": fn(a, b) { Cents(a[0] + b[0]) } }; Cents(1) + Cents(2)`, "Cents(3)"},
+		{`impl Point { "<": fn(a, b) { true } }`, "Error: cannot implement < for Point"},
+		{`new(1, {})`, "Error: first argument to `new` must be STRING, got INTEGER"},
+		{`to_string([1, true])`, "[1, true]"},
+	}
+	testInspections(t, tests)
+}
+
+func TestInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`2 in [1, 2, 3]`, "true"},
+		{`4 in [1, 2, 3]`, "false"},
+		{`let a = [1]; a in [a]`, "true"},
+		{`"a" in {"a": 1}`, "true"},
+		{`"b" in {"a": 1}`, "false"},
+		{`let h = {"gone": first([])}; "gone" in h`, "true"},
+		{`2 in #{1, 2}`, "true"},
+		{`5 in 0..5`, "false"},
+		{`"key" in "monkey"`, "true"},
+		{`"Key" in "monkey"`, "false"},
+		{`[x for x in 0..6 if x in [1, 4, 9]]`, "[1, 4]"},
+		{`1 in 5`, "Error: unknown operator: INTEGER in INTEGER"},
+		{`1 in "abc"`, "Error: unknown operator: INTEGER in STRING"},
+		{`[1] in {"a": 1}`, "false"},
+		{`[1, 2] in {[1, 2]: "a"}`, "true"},
+		{`[fn(x) { x }] in {"a": 1}`, "Error: unusable as hash key: ARRAY containing FUNCTION"},
+	}
+	testInspections(t, tests)
+}
+
+func TestPipeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let double = fn(x) { x * 2 }; 3 |> double`, "6"},
+		{`let sub = fn(a, b) { a - b }; 10 |> sub(3)`, "7"},
+		{`let map = fn(xs, f) { [f(x) for x in xs] };
+		  let keep = fn(xs, f) { [x for x in xs if f(x)] };
+		  [1, 2, 3, 4] |> keep(fn(x) { x > 2 }) |> map(fn(x) { x * 10 })`, "[30, 40]"},
+		{`"monkey" |> len`, "6"},
+		{`1 + 2 |> fn(x) { x * 2 }`, "6"},
+		{`[1] |> push(2) |> push(3)`, "[1, 2, 3]"},
+		{`1 |> 2`, "Error: not a function: INTEGER"},
+		{`1 |> missing(3)`, "Error: identifier not found: missing"},
+		{`-true |> len`, "Error: unknown operator: -BOOLEAN"},
+	}
+	testInspections(t, tests)
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[x * 2 for x in [3, -1, 4] if x > 0]`, "[6, 8]"},
+		{`[x for x in []]`, "[]"},
+		{`[i * 10 + x for i, x in [5, 6]]`, "[5, 16]"},
+		{`len([[y for y in 0..x] for x in 0..4][3])`, "3"},
+		{`let x = 99; [x for x in [1]]; x`, "99"},
+		{`let fs = [fn() { i } for i in 0..3]; [g() for g in fs]`, "[0, 1, 2]"},
+		{`let h = {k: v * 10 for k, v in {"a": 1, "b": 2}}; [h["a"], h["b"], len([k for k in h])]`, "[10, 20, 2]"},
+		{`{x: x * x for x in 1..3 if x > 1}`, "{2: 4}"},
+		{`[x for x in 5]`, "Error: cannot iterate over INTEGER"},
+		{`[x for k, v in 5]`, "Error: cannot iterate over key/value pairs of INTEGER"},
+		{`{[x]: x for x in [1]}`, "{[1]: 1}"},
+		{`{[x, fn() { x }]: x for x in [1]}`, "Error: unusable as hash key: ARRAY containing FUNCTION"},
+		{`[x + true for x in [1]]`, "Error: type mismatch: INTEGER + BOOLEAN"},
+	}
+	testInspections(t, tests)
+}
+
+func TestSetLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`#{}`, "#{}"},
+		{`#{1, 2, 3}`, "#{1, 2, 3}"},
+		{`#{1 + 1, 2, 4 / 2, "a", "a", true}`, `#{2, a, true}`},
+		{`set([3, 1, 3, 2])`, "#{3, 1, 2}"},
+		{`len(#{1, 2, 2, 3})`, "3"},
+		{`union(#{1, 2}, #{2, 3})`, "#{1, 2, 3}"},
+		{`intersection(#{1, 2, 3}, #{3, 2, 4})`, "#{2, 3}"},
+		{`difference(#{1, 2, 3}, #{2})`, "#{1, 3}"},
+		{`subset(#{1, 2}, #{1, 2, 3})`, "true"},
+		{`subset(#{1, 4}, #{1, 2, 3})`, "false"},
+		{`has(#{"a", "b"}, "a")`, "true"},
+		{`has(#{1, 2}, [1])`, "false"},
+		{`if (has(#{1}, 1)) { "yes" } else { "no" }`, "yes"},
+		{`#{[1], [1], [2]}`, "#{[1], [2]}"},
+		{`#{({"a": [len]}, 1)}`, "Error: unusable as set element: TUPLE containing BUILTIN"},
+		{`set([fn(x) { x }])`, "Error: unusable as set element: FUNCTION"},
+		{`union(#{1}, [1])`, "Error: arguments to `union` must be SET, got ARRAY"},
+	}
+	testInspections(t, tests)
+}
+
 func TestArrayIndexExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -369,7 +764,9 @@ func TestArrayIndexExpression(t *testing.T) {
 		{"let myArray = [1, 2, 3]; myArray[2]", 3},
 		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
 		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%s", tt.input), func(t *testing.T) {
@@ -402,23 +799,24 @@ let two = "two";
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. Got %T (%+v)", eval, eval)
 	}
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := map[object.Object]int64{
+		&object.String{Value: "one"}:   1,
+		&object.String{Value: "two"}:   2,
+		&object.String{Value: "three"}: 3,
+		&object.Integer{Value: 4}:      4,
+		TRUE:                           5,
+		FALSE:                          6,
 	}
-	if len(expected) != len(result.Pairs) {
-		t.Fatalf("Hash has wrong number of pairs. Got %d", len(result.Pairs))
+	if len(expected) != result.Len() {
+		t.Fatalf("Hash has wrong number of pairs. Got %d", result.Len())
 	}
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		value, ok := result.Get(expectedKey)
 		if !ok {
-			t.Errorf("no pair for given key in Pairs %d", expectedKey.Value)
+			t.Errorf("no pair for given key in Pairs %s", expectedKey.Inspect())
+			continue
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, expectedValue)
 	}
 
 }
@@ -458,6 +856,23 @@ func testEval(input string) object.Object {
 	return Eval(program, env)
 }
 
+// testInspections evaluates each input and compares what the result inspects
+// as with the expected string.
+func testInspections(t *testing.T, tests []struct {
+	input    string
+	expected string
+}) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result. want=%s, got=%s", tt.expected, evaluated.Inspect())
+			}
+		})
+	}
+}
+
 func testIntegerObject(t *testing.T, evaluated object.Object, expected int64) bool {
 	result, ok := evaluated.(*object.Integer)
 	if !ok {
@@ -482,3 +897,133 @@ func testBooleanObject(t *testing.T, evaluated object.Object, expected bool) boo
 	}
 	return true
 }
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g)", 1},
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g); next(g)", 2},
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g); next(g); next(g)", nil},
+		{"let gen = fn*(a, b) { yield a + b; }; next(gen(2, 3))", 5},
+		{"let gen = fn*() { yield 1; return 5; yield 2; }; let g = gen(); next(g); next(g)", nil},
+		{"let gen = fn*() { yield 10; }; first(gen())", 10},
+		{"let gen = fn*() { }; first(gen())", nil},
+		{`
+let counter = fn*(n) {
+	let loop = fn*(i) {
+		yield i;
+		for (x in loop(i + 1)) { yield x; }
+	};
+	for (x in loop(0)) {
+		if (x > n - 1) { return 0; }
+		yield x;
+	}
+};
+let g = counter(3);
+next(g) + next(g) * 10 + next(g) * 100
+`, 210},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s", tt.input), func(t *testing.T) {
+			eval := testEval(tt.input)
+			integer, ok := tt.expected.(int)
+			if ok {
+				testIntegerObject(t, eval, int64(integer))
+			} else {
+				testNullObjects(t, eval)
+			}
+		})
+	}
+}
+
+func TestAbandonedGeneratorsExit(t *testing.T) {
+	before := goruntime.NumGoroutine()
+	testEval(`
+let gen = fn*() { yield 1; yield 2; };
+let first_of = fn() { for (x in gen()) { return x; } };
+for (i in 0..50) { next(gen()); first_of(); }
+`)
+	for i := 0; i < 100 && goruntime.NumGoroutine() > before; i++ {
+		goruntime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := goruntime.NumGoroutine(); n > before {
+		t.Errorf("abandoned generators left goroutines running. before=%d, after=%d", before, n)
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let find = fn(xs) { for (x in xs) { if (x > 1) { return x; } } return 0; }; find([1, 2, 3])", 2},
+		{"let gen = fn*() { yield 1; yield 2; }; let f = fn() { for (x in gen()) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let f = fn() { for (x in []) { return 1; } 0 }; f()", 0},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"let gen = fn*() { yield 1; -true; }; let f = fn() { for (x in gen()) { } }; f()", "unknown operator: -BOOLEAN"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s", tt.input), func(t *testing.T) {
+			eval := testEval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, eval, int64(expected))
+			case string:
+				if eval.Inspect() != expected && eval.Inspect() != "Error: "+expected {
+					t.Errorf("wrong result. want=%q, got=%q", expected, eval.Inspect())
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let c = channel(1); send(c, 5); recv(c)", 5},
+		{"let r = spawn(fn(a, b) { a + b }, 2, 3); recv(r)", 5},
+		{"let c = channel(); spawn(fn() { send(c, 10) }); recv(c)", 10},
+		{"let x = 5; let r = spawn(fn() { x * 2 }); recv(r)", 10},
+		{"let c = channel(10); let worker = fn(n) { send(c, n) }; spawn(worker, 1); spawn(worker, 2); recv(c) + recv(c)", 3},
+		{"let c = channel(1); close(c); recv(c)", nil},
+		{"let c = channel(1); send(c, 3); select { case x = recv(c) { x * 2 } }", 6},
+		{"let c = channel(); select { case recv(c) { 1 } default { 2 } }", 2},
+		{"let c = channel(1); select { case send(c, 4) { recv(c) } }", 4},
+		{"let a = channel(); let b = channel(1); send(b, 7); select { case x = recv(a) { x } case y = recv(b) { y + 1 } }", 8},
+		{"let c = channel(1); close(c); select { case x = recv(c) { x } }", nil},
+		{"let c = channel(); select { case recv(c) { 1 } default { } }", nil},
+		{"let c = channel(1); close(c); close(c)", "close of closed channel"},
+		{"let c = channel(1); close(c); send(c, 1)", "send on closed channel"},
+		{"let c = channel(1); close(c); select { case send(c, 1) { 1 } }", "send on closed channel"},
+		{"spawn(1)", "cannot spawn INTEGER"},
+		{"select { case recv(1) { 1 } }", "select case is not a channel: INTEGER"},
+		{"recv(spawn(fn() { -true }))", "unknown operator: -BOOLEAN"},
+		{"channel(-1)", "negative channel size -1"},
+		{"channel(9223372036854775807)", "channel size 9223372036854775807 is larger than 1048576"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s", tt.input), func(t *testing.T) {
+			eval := testEval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, eval, int64(expected))
+			case string:
+				err, ok := eval.(*object.Error)
+				if !ok {
+					t.Errorf("object is not Error. Got %T (%+v)", eval, eval)
+					return
+				}
+				if err.Message != expected {
+					t.Errorf("Wrong error message. Expected %q, got %q", expected, err.Message)
+				}
+			default:
+				testNullObjects(t, eval)
+			}
+		})
+	}
+}
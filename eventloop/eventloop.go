@@ -0,0 +1,230 @@
+package eventloop
+
+import (
+	"container/heap"
+	"fmt"
+	"monkey/object"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a Loop. Tests swap in a clock whose Sleep
+// only moves Now forward.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Loop holds the timers a program schedules with set_timeout and
+// set_interval. The host runs it once the program itself has finished.
+type Loop struct {
+	clock Clock
+
+	mu     sync.Mutex
+	timers timerQueue
+	byID   map[int64]*timer
+	nextID int64
+}
+
+type timer struct {
+	id       int64
+	fn       object.Object
+	when     time.Time
+	interval time.Duration // zero for one-shot timers
+	index    int           // position in the queue, maintained by heap
+}
+
+func New() *Loop {
+	return NewWithClock(realClock{})
+}
+
+func NewWithClock(clock Clock) *Loop {
+	return &Loop{clock: clock, byID: make(map[int64]*timer)}
+}
+
+// Run fires timers in due order, sleeping until each is due, until none are
+// left. call invokes a callback in whichever engine scheduled it; an error
+// from it stops the loop.
+func (l *Loop) Run(call func(fn object.Object) error) error {
+	for {
+		t, ok := l.nextDue()
+		if !ok {
+			return nil
+		}
+		if err := call(t.fn); err != nil {
+			return err
+		}
+	}
+}
+
+// Pending reports how many timers are still scheduled.
+func (l *Loop) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.timers)
+}
+
+// nextDue waits for the earliest timer and takes it off the queue, or
+// reschedules it if it repeats. It reports false once no timers are left.
+func (l *Loop) nextDue() (*timer, bool) {
+	for {
+		l.mu.Lock()
+		if len(l.timers) == 0 {
+			l.mu.Unlock()
+			return nil, false
+		}
+		t := l.timers[0]
+		wait := t.when.Sub(l.clock.Now())
+		if wait <= 0 {
+			if t.interval > 0 {
+				t.when = t.when.Add(t.interval)
+				heap.Fix(&l.timers, t.index)
+			} else {
+				heap.Pop(&l.timers)
+				delete(l.byID, t.id)
+			}
+			l.mu.Unlock()
+			return t, true
+		}
+		l.mu.Unlock()
+
+		// a callback on another goroutine may change the queue meanwhile, so
+		// look again at whichever timer is earliest after waking up
+		l.clock.Sleep(wait)
+	}
+}
+
+func (l *Loop) schedule(fn object.Object, delay time.Duration, repeat bool) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	t := &timer{id: l.nextID, fn: fn, when: l.clock.Now().Add(delay)}
+	if repeat {
+		t.interval = delay
+	}
+	heap.Push(&l.timers, t)
+	l.byID[t.id] = t
+	return t.id
+}
+
+func (l *Loop) clear(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.byID[id]
+	if !ok {
+		return
+	}
+	heap.Remove(&l.timers, t.index)
+	delete(l.byID, id)
+}
+
+// Builtins returns set_timeout, set_interval and clear_timer bound to this
+// loop. Hosts define them as globals next to the regular builtins.
+func (l *Loop) Builtins() []struct {
+	Name    string
+	Builtin *object.Builtin
+} {
+	return []struct {
+		Name    string
+		Builtin *object.Builtin
+	}{
+		{"set_timeout", &object.Builtin{Fn: l.timerBuiltin("set_timeout", false)}},
+		{"set_interval", &object.Builtin{Fn: l.timerBuiltin("set_interval", true)}},
+		{"clear_timer", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			id, ok := args[0].(*object.Integer)
+			if !ok {
+				return newError("argument to `clear_timer` must be INTEGER, got %s", args[0].Type())
+			}
+			l.clear(id.Value)
+			return nil
+		}}},
+	}
+}
+
+func (l *Loop) timerBuiltin(name string, repeat bool) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=2", len(args))
+		}
+		switch args[0].(type) {
+		case *object.Function, *object.Closure, *object.Builtin:
+		default:
+			return newError("first argument to `%s` must be a function, got %s", name, args[0].Type())
+		}
+		ms, ok := args[1].(*object.Integer)
+		if !ok {
+			return newError("second argument to `%s` must be INTEGER, got %s", name, args[1].Type())
+		}
+		if ms.Value < 0 || (repeat && ms.Value == 0) {
+			return newError("invalid delay for `%s`: %d", name, ms.Value)
+		}
+		id := l.schedule(args[0], time.Duration(ms.Value)*time.Millisecond, repeat)
+		return &object.Integer{Value: id}
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// timerQueue is a min-heap of timers ordered by due time, then by creation.
+type timerQueue []*timer
+
+func (q timerQueue) Len() int { return len(q) }
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].when.Equal(q[j].when) {
+		return q[i].id < q[j].id
+	}
+	return q[i].when.Before(q[j].when)
+}
+func (q timerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *timerQueue) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+func (q *timerQueue) Pop() any {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return t
+}
+
+// ManualClock never blocks: Sleep moves the clock forward instead, so timers
+// fire instantly and in a deterministic order.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
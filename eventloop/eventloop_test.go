@@ -0,0 +1,139 @@
+package eventloop_test
+
+import (
+	"fmt"
+	"monkey/eventloop"
+	"monkey/object"
+	"monkey/runner"
+	"testing"
+	"time"
+)
+
+// engines are the engines every program is run with, through the same
+// runner the monkey command uses.
+var engines = []string{runner.EngineVM, runner.EngineEval}
+
+func TestTimers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`set_timeout(fn() { record("b") }, 20);
+			set_timeout(fn() { record("a") }, 10);
+			set_timeout(fn() { record("c") }, 20);`,
+			[]string{"a@10", "b@20", "c@20"},
+		},
+		{
+			`set_timeout(fn() { record("now") }, 0); record("sync")`,
+			[]string{"sync@0", "now@0"},
+		},
+		{
+			`let id = set_interval(fn() { record("tick") }, 10);
+			set_timeout(fn() { clear_timer(id) }, 35);`,
+			[]string{"tick@10", "tick@20", "tick@30"},
+		},
+		{
+			`let id = set_timeout(fn() { record("never") }, 10);
+			clear_timer(id);
+			set_timeout(fn() {
+				set_timeout(fn() { record("nested") }, 5);
+			}, 10);`,
+			[]string{"nested@15"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			clock := eventloop.NewManualClock(time.Unix(0, 0))
+			loop := eventloop.NewWithClock(clock)
+			var fired []string
+			record := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+				ms := clock.Now().Sub(time.Unix(0, 0)).Milliseconds()
+				fired = append(fired, fmt.Sprintf("%s@%d", args[0].(*object.String).Value, ms))
+				return nil
+			}}
+
+			err := runner.Run(tt.input, engine, loop, runner.Global{Name: "record", Value: record})
+			if err != nil {
+				t.Fatalf("%s: run failed: %s", engine, err)
+			}
+			if fmt.Sprint(fired) != fmt.Sprint(tt.expected) {
+				t.Errorf("%s: wrong order. want=%v, got=%v", engine, tt.expected, fired)
+			}
+			if loop.Pending() != 0 {
+				t.Errorf("%s: timers left over: %d", engine, loop.Pending())
+			}
+		}
+	}
+}
+
+// hookClock runs hook during the first Sleep, like a goroutine changing the
+// timers while the loop waits.
+type hookClock struct {
+	*eventloop.ManualClock
+	hook func()
+}
+
+func (c *hookClock) Sleep(d time.Duration) {
+	if hook := c.hook; hook != nil {
+		c.hook = nil
+		hook()
+	}
+	c.ManualClock.Sleep(d)
+}
+
+func TestTimerClearedWhileWaiting(t *testing.T) {
+	for _, engine := range engines {
+		clock := &hookClock{ManualClock: eventloop.NewManualClock(time.Unix(0, 0))}
+		loop := eventloop.NewWithClock(clock)
+		var fired []string
+		record := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			ms := clock.Now().Sub(time.Unix(0, 0)).Milliseconds()
+			fired = append(fired, fmt.Sprintf("%s@%d", args[0].(*object.String).Value, ms))
+			return nil
+		}}
+		clock.hook = func() {
+			for _, b := range loop.Builtins() {
+				if b.Name == "clear_timer" {
+					b.Builtin.Fn(&object.Integer{Value: 1})
+				}
+			}
+		}
+
+		input := `set_timeout(fn() { record("cleared") }, 10);
+		set_timeout(fn() { record("later") }, 30);`
+		err := runner.Run(input, engine, loop, runner.Global{Name: "record", Value: record})
+		if err != nil {
+			t.Fatalf("%s: run failed: %s", engine, err)
+		}
+		if fmt.Sprint(fired) != "[later@30]" {
+			t.Errorf("%s: wrong timers fired. want=[later@30], got=%v", engine, fired)
+		}
+	}
+}
+
+func TestTimerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`set_timeout(1, 10)`, "first argument to `set_timeout` must be a function, got INTEGER"},
+		{`set_timeout(fn() {}, "10")`, "second argument to `set_timeout` must be INTEGER, got STRING"},
+		{`set_timeout(fn() {}, -1)`, "invalid delay for `set_timeout`: -1"},
+		{`set_interval(fn() {}, 0)`, "invalid delay for `set_interval`: 0"},
+		{`set_timeout(fn() { clear_timer("1") }, 10)`, "argument to `clear_timer` must be INTEGER, got STRING"},
+		{`set_interval(fn() { set_timeout() }, 10)`, "wrong number of arguments. got=0, want=2"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			loop := eventloop.NewWithClock(eventloop.NewManualClock(time.Unix(0, 0)))
+
+			err := runner.Run(tt.input, engine, loop)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("%s: wrong error. want=%q, got=%v", engine, tt.expected, err)
+			}
+		}
+	}
+}
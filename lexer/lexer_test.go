@@ -30,6 +30,11 @@ if ( 5 < 10) {
 "foo\"bar"
 [1, 2]
 { "foo": "bar" }
+#{1}
+a[1:]
+0..n
+a |> f
+sort!(a) a!=b
 `
 
 	tests := []struct {
@@ -132,6 +137,32 @@ if ( 5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		// #{1}
+		{token.LSET, "#{"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		// a[1:]
+		{token.IDENT, "a"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COLON, ":"},
+		{token.RBRACKET, "]"},
+		// 0..n
+		{token.INT, "0"},
+		{token.DOTDOT, ".."},
+		{token.IDENT, "n"},
+		// a |> f
+		{token.IDENT, "a"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		// sort!(a) a!=b
+		{token.IDENT, "sort!"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.RPAREN, ")"},
+		{token.IDENT, "a"},
+		{token.NOT_EQ, "!="},
+		{token.IDENT, "b"},
 
 		{token.EOF, ""},
 	}
@@ -148,3 +179,37 @@ if ( 5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"a b\"\n\tfn"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+", 2, 5},
+		{"a b", 2, 7},
+		{"fn", 3, 2},
+		{"", 3, 4},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position of %q wrong. Expected %d:%d, got %d:%d",
+				i, tt.expectedLiteral, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
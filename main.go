@@ -1,13 +1,26 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"monkey/eventloop"
+	"monkey/lexer"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/runner"
+	"monkey/typecheck"
 	"os"
 	"os/user"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(run(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(check(os.Args[2:]))
+	}
+
 	u, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -17,3 +30,67 @@ func main() {
 
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// run executes a script file: monkey run [-engine=vm|eval] file
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	engine := flags.String("engine", runner.EngineVM, "engine to run the script with (vm or eval)")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey run [-engine=vm|eval] file")
+		return 2
+	}
+
+	source, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = runner.Run(string(source), *engine, eventloop.New())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// check infers the types of a script without running it and prints the type
+// of every top-level binding: monkey check file
+func check(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey check file")
+		return 2
+	}
+
+	source, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, e := range p.Errors() {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return 1
+	}
+
+	inferrer := typecheck.NewInferrer()
+	for _, v := range eventloop.New().Builtins() {
+		inferrer.Define(v.Name, typecheck.Any)
+	}
+	bindings, errs := inferrer.Infer(program)
+	for _, b := range bindings {
+		fmt.Println(b)
+	}
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", args[0], e)
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+	return 0
+}
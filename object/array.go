@@ -0,0 +1,233 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// Arrays are values to push, rest and every other builtin that returns an
+// array: the result is a new array and the argument is left alone. To keep
+// that cheap, push and rest let the new array share its elements with the
+// old one. The in-place operations (Append, Pop, Insert, Remove, Reverse and
+// sorting) change the array they are given, and so whatever else refers to
+// that same array, but never an array derived from it: an array that may
+// share its elements first copies them. Changes are not synchronised, so an
+// array must not be changed while another goroutine uses it.
+//
+// Unlike Hash, Array is not a persistent trie. Both engines and the builtins
+// use Elements as a plain slice, and a trie would turn each index into a walk
+// down the tree. Instead the arrays made by push and rest share one backing
+// slice: the newest array grows into its spare capacity, and rest takes a
+// subslice, both in constant time. The cost falls on pushing onto an older
+// array, whose next slot is already taken, which copies its elements once.
+//
+// Hash keys and set elements must keep the hash they were stored under, so
+// what is stored is a frozen copy of an array, which the in-place operations
+// refuse to change. Arrays derived from a frozen one are not frozen.
+
+// snapshot returns key as it is now, for storing as a hash key or set
+// element: arrays are replaced by frozen copies, as are the values holding
+// them. Other values are returned as they are.
+func snapshot(key Object) Object {
+	switch key := key.(type) {
+	case *Array:
+		if key.frozen {
+			return key
+		}
+		elements, _ := snapshots(key.Elements)
+		return &Array{Elements: elements, frozen: true}
+	case *Tuple:
+		if elements, changed := snapshots(key.Elements); changed {
+			return &Tuple{Elements: elements}
+		}
+	case *Variant:
+		if values, changed := snapshots(key.Values); changed {
+			v := *key
+			v.Values = values
+			return &v
+		}
+	case *Hash:
+		pairs := key.OrderedPairs()
+		values := make([]Object, len(pairs))
+		for i, pair := range pairs {
+			values[i] = pair.Value
+		}
+		if values, changed := snapshots(values); changed {
+			result := NewHash()
+			result.Tag = key.Tag
+			for i, pair := range pairs {
+				result.Put(pair.Key, values[i])
+			}
+			return result
+		}
+	}
+	return key
+}
+
+// snapshots applies snapshot to each of values. It reports whether any of
+// them changed.
+func snapshots(values []Object) ([]Object, bool) {
+	result := make([]Object, len(values))
+	changed := false
+	for i, val := range values {
+		result[i] = snapshot(val)
+		changed = changed || result[i] != val
+	}
+	return result, changed
+}
+
+// backing is the spare capacity of a backing array shared by arrays that
+// Push and Rest derived from one another. Only an array ending where the
+// spare capacity begins may grow into it, so every array keeps seeing just
+// its own elements.
+type backing struct {
+	free int64 // updated atomically, as arrays are shared between goroutines
+}
+
+// Push returns a new array with el after the elements of a. When a ends at
+// the spare capacity of its backing array the new array takes one slot of
+// it; otherwise the elements are copied into a backing array with room to
+// grow. Building an array by pushing is thus amortised constant time a push.
+func (a *Array) Push(el Object) *Array {
+	if a.grow() {
+		return &Array{Elements: append(a.Elements, el), shared: a.shared}
+	}
+	elements := make([]Object, len(a.Elements), 2*len(a.Elements)+1)
+	copy(elements, a.Elements)
+	elements = append(elements, el)
+	return &Array{Elements: elements, shared: &backing{free: int64(cap(elements) - len(elements))}}
+}
+
+// Rest returns a new array without the first element of a. An array made by
+// Push or Rest shares the remaining elements with it; any other array has
+// its elements to itself, so they are copied once.
+func (a *Array) Rest() *Array {
+	if a.shared == nil {
+		elements := make([]Object, len(a.Elements)-1)
+		copy(elements, a.Elements[1:])
+		return &Array{Elements: elements, shared: &backing{}}
+	}
+	return &Array{Elements: a.Elements[1:], shared: a.shared}
+}
+
+// grow claims the slot after the elements of a from the spare capacity of
+// its backing array. It reports false when a does not end where the spare
+// capacity begins or there is none left.
+func (a *Array) grow() bool {
+	if a.shared == nil {
+		return false
+	}
+	free := int64(cap(a.Elements) - len(a.Elements))
+	return free > 0 && atomic.CompareAndSwapInt64(&a.shared.free, free, free-1)
+}
+
+// own gives a a backing array of its own, copying the elements if other
+// arrays may share them, so that they can be changed in place.
+func (a *Array) own() {
+	if a.shared == nil {
+		return
+	}
+	elements := make([]Object, len(a.Elements))
+	copy(elements, a.Elements)
+	a.Elements = elements
+	a.shared = nil
+}
+
+// Append adds el after the elements of a.
+func (a *Array) Append(el Object) {
+	if !a.grow() {
+		a.own()
+	}
+	a.Elements = append(a.Elements, el)
+}
+
+// Pop removes the last element of a and returns it, or NULL when a is empty.
+func (a *Array) Pop() Object {
+	n := len(a.Elements)
+	if n == 0 {
+		return NULL
+	}
+	el := a.Elements[n-1]
+	if a.shared == nil {
+		a.Elements[n-1] = nil
+	}
+	a.Elements = a.Elements[:n-1]
+	return el
+}
+
+// Insert puts el at position i, which may be one past the last element.
+func (a *Array) Insert(i int, el Object) {
+	a.own()
+	a.Elements = append(a.Elements, nil)
+	copy(a.Elements[i+1:], a.Elements[i:])
+	a.Elements[i] = el
+}
+
+// Remove takes the element at position i out of a and returns it.
+func (a *Array) Remove(i int) Object {
+	a.own()
+	el := a.Elements[i]
+	copy(a.Elements[i:], a.Elements[i+1:])
+	a.Elements[len(a.Elements)-1] = nil
+	a.Elements = a.Elements[:len(a.Elements)-1]
+	return el
+}
+
+// Reverse puts the elements of a in the opposite order.
+func (a *Array) Reverse() {
+	a.own()
+	for i, j := 0, len(a.Elements)-1; i < j; i, j = i+1, j-1 {
+		a.Elements[i], a.Elements[j] = a.Elements[j], a.Elements[i]
+	}
+}
+
+// Sort sorts the elements of a by cmp, keeping equal elements in their
+// order. When cmp fails a is left as it was.
+func (a *Array) Sort(cmp func(x, y Object) (int, error)) error {
+	elements := make([]Object, len(a.Elements))
+	copy(elements, a.Elements)
+	var err error
+	sort.SliceStable(elements, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var c int
+		c, err = cmp(elements[i], elements[j])
+		return c < 0
+	})
+	if err != nil {
+		return err
+	}
+	a.Elements = elements
+	a.shared = nil
+	return nil
+}
+
+// compare orders integers by value and strings by their bytes. Other values,
+// and values of different types, cannot be compared.
+func compare(x, y Object) (int, error) {
+	switch x := x.(type) {
+	case *Integer:
+		if y, ok := y.(*Integer); ok {
+			switch {
+			case x.Value < y.Value:
+				return -1, nil
+			case x.Value > y.Value:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case *String:
+		if y, ok := y.(*String); ok {
+			switch {
+			case x.Value < y.Value:
+				return -1, nil
+			case x.Value > y.Value:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", x.Type(), y.Type())
+}
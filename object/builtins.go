@@ -0,0 +1,1037 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtins is shared by both engines. The compiler refers to builtins by
+// their index in this slice, so new entries go at the end.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if fn, ok := rt.Method(args[0], "len"); ok {
+				return rt.Call(fn, args[0])
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Set:
+				return &Integer{Value: int64(arg.Len())}
+			case *Tuple:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(arg.Len())}
+			case *Range:
+				return &Integer{Value: arg.Len()}
+			}
+			return newError("argument to `len` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"puts",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			for _, arg := range args {
+				str := ToString(rt, arg)
+				if str.Type() == ERROR_OBJ {
+					return str
+				}
+				fmt.Println(str.(*String).Value)
+			}
+			return nil
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				if len(arg.Elements) > 0 {
+					return arg.Elements[0]
+				}
+				return nil
+			case *Generator:
+				value, ok := arg.Next()
+				if !ok {
+					return nil
+				}
+				return value
+			}
+			return newError("argument to `first` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				if len(arg.Elements) > 0 {
+					return arg.Elements[len(arg.Elements)-1]
+				}
+				return nil
+			}
+			return newError("argument to `last` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				if len(arg.Elements) > 0 {
+					return arg.Rest()
+				}
+				return nil
+			}
+			return newError("argument to `rest` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return arg.Push(args[1])
+			}
+			return newError("argument to `push` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"next",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case Iterator:
+				value, ok := arg.Next()
+				if !ok {
+					return nil
+				}
+				return value
+			}
+			return newError("argument to `next` not supported, got %s", args[0].Type())
+		}},
+	},
+	{
+		"channel",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+			if len(args) == 0 {
+				return NewChannel(0)
+			}
+			size, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `channel` must be INTEGER, got %s", args[0].Type())
+			}
+			if size.Value < 0 {
+				return newError("negative channel size %d", size.Value)
+			}
+			if size.Value > maxChannelSize {
+				return newError("channel size %d is larger than %d", size.Value, maxChannelSize)
+			}
+			return NewChannel(int(size.Value))
+		}},
+	},
+	{
+		"send",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `send` must be CHANNEL, got %s", args[0].Type())
+			}
+			if err := ch.Send(args[1]); err != nil {
+				return newError("%s", err)
+			}
+			return nil
+		}},
+	},
+	{
+		"recv",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `recv` must be CHANNEL, got %s", args[0].Type())
+			}
+			value, ok := ch.Recv()
+			if !ok {
+				return nil
+			}
+			return value
+		}},
+	},
+	{
+		"close",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `close` must be CHANNEL, got %s", args[0].Type())
+			}
+			if err := ch.Close(); err != nil {
+				return newError("%s", err)
+			}
+			return nil
+		}},
+	},
+	{
+		"set",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `set` must be ARRAY, got %s", args[0].Type())
+			}
+			set := NewSet()
+			for _, el := range arr.Elements {
+				if !set.Add(el) {
+					return newError("%s", KeyError("set element", el))
+				}
+			}
+			return set
+		}},
+	},
+	{
+		"union",
+		&Builtin{Fn: setOperation("union", func(a, b *Set) Object { return a.Union(b) })},
+	},
+	{
+		"intersection",
+		&Builtin{Fn: setOperation("intersection", func(a, b *Set) Object { return a.Intersection(b) })},
+	},
+	{
+		"difference",
+		&Builtin{Fn: setOperation("difference", func(a, b *Set) Object { return a.Difference(b) })},
+	},
+	{
+		"subset",
+		&Builtin{Fn: setOperation("subset", func(a, b *Set) Object {
+			return nativeBoolToBooleanObject(a.IsSubset(b))
+		})},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			switch container := args[0].(type) {
+			case *Set:
+				return nativeBoolToBooleanObject(container.Has(args[1]))
+			case *Hash:
+				_, found := container.Get(args[1])
+				return nativeBoolToBooleanObject(found)
+			}
+			return newError("first argument to `has` must be SET or HASH, got %s", args[0].Type())
+		}},
+	},
+	{
+		"tag",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			variant, ok := args[0].(*Variant)
+			if !ok {
+				return newError("argument to `tag` must be VARIANT, got %s", args[0].Type())
+			}
+			return &String{Value: variant.Tag}
+		}},
+	},
+	{
+		"new",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			tag, ok := args[0].(*String)
+			if !ok {
+				return newError("first argument to `new` must be STRING, got %s", args[0].Type())
+			}
+			hash, ok := args[1].(*Hash)
+			if !ok {
+				return newError("second argument to `new` must be HASH, got %s", args[1].Type())
+			}
+			return hash.Tagged(tag.Value)
+		}},
+	},
+	{
+		"to_string",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return ToString(rt, args[0])
+		}},
+	},
+	{
+		"split",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("split", args, STRING_OBJ, STRING_OBJ); err != nil {
+				return err
+			}
+			parts := strings.Split(args[0].(*String).Value, args[1].(*String).Value)
+			return stringArray(parts)
+		}},
+	},
+	{
+		"join",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("join", args, ARRAY_OBJ, STRING_OBJ); err != nil {
+				return err
+			}
+			elements := args[0].(*Array).Elements
+			parts := make([]string, len(elements))
+			for i, el := range elements {
+				str, ok := el.(*String)
+				if !ok {
+					return newError("elements joined by `join` must be STRING, got %s", el.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &String{Value: strings.Join(parts, args[1].(*String).Value)}
+		}},
+	},
+	{
+		"trim",
+		&Builtin{Fn: stringFunction("trim", strings.TrimSpace)},
+	},
+	{
+		"upper",
+		&Builtin{Fn: stringFunction("upper", strings.ToUpper)},
+	},
+	{
+		"lower",
+		&Builtin{Fn: stringFunction("lower", strings.ToLower)},
+	},
+	{
+		"replace",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("replace", args, STRING_OBJ, STRING_OBJ, STRING_OBJ); err != nil {
+				return err
+			}
+			str := args[0].(*String).Value
+			return &String{Value: strings.ReplaceAll(str, args[1].(*String).Value, args[2].(*String).Value)}
+		}},
+	},
+	{
+		"contains",
+		&Builtin{Fn: stringPredicate("contains", strings.Contains)},
+	},
+	{
+		"starts_with",
+		&Builtin{Fn: stringPredicate("starts_with", strings.HasPrefix)},
+	},
+	{
+		"ends_with",
+		&Builtin{Fn: stringPredicate("ends_with", strings.HasSuffix)},
+	},
+	{
+		"index_of",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("index_of", args, STRING_OBJ, STRING_OBJ); err != nil {
+				return err
+			}
+			str := args[0].(*String).Value
+			i := strings.Index(str, args[1].(*String).Value)
+			if i < 0 {
+				return &Integer{Value: -1}
+			}
+			// strings are indexed by character, not by byte
+			return &Integer{Value: int64(utf8.RuneCountInString(str[:i]))}
+		}},
+	},
+	{
+		"repeat",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("repeat", args, STRING_OBJ, INTEGER_OBJ); err != nil {
+				return err
+			}
+			count := args[1].(*Integer).Value
+			if count < 0 {
+				return newError("second argument to `repeat` must not be negative, got %d", count)
+			}
+			str := args[0].(*String).Value
+			if len(str) > 0 && count > maxStringLength/int64(len(str)) {
+				return newError("result of `repeat` would be longer than %d bytes", maxStringLength)
+			}
+			return &String{Value: strings.Repeat(str, int(count))}
+		}},
+	},
+	{
+		"pad_left",
+		&Builtin{Fn: pad("pad_left", func(s, padding string) string { return padding + s })},
+	},
+	{
+		"pad_right",
+		&Builtin{Fn: pad("pad_right", func(s, padding string) string { return s + padding })},
+	},
+	{
+		"chars",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("chars", args, STRING_OBJ); err != nil {
+				return err
+			}
+			var chars []string
+			for _, r := range args[0].(*String).Value {
+				chars = append(chars, string(r))
+			}
+			return stringArray(chars)
+		}},
+	},
+	{
+		"map",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("map", args, 2)
+			if err != nil {
+				return err
+			}
+			result := &Array{}
+			for el, ok := it.Next(); ok; el, ok = it.Next() {
+				value := rt.Call(fn, el)
+				if isError(value) {
+					return value
+				}
+				result.Elements = append(result.Elements, value)
+			}
+			return result
+		}},
+	},
+	{
+		"filter",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("filter", args, 2)
+			if err != nil {
+				return err
+			}
+			result := &Array{}
+			for el, ok := it.Next(); ok; el, ok = it.Next() {
+				keep := rt.Call(fn, el)
+				if isError(keep) {
+					return keep
+				}
+				if isTruthy(keep) {
+					result.Elements = append(result.Elements, el)
+				}
+			}
+			return result
+		}},
+	},
+	{
+		"reduce",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("reduce", args, 3)
+			if err != nil {
+				return err
+			}
+			acc := args[1]
+			for el, ok := it.Next(); ok; el, ok = it.Next() {
+				acc = rt.Call(fn, acc, el)
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		}},
+	},
+	{
+		"each",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("each", args, 2)
+			if err != nil {
+				return err
+			}
+			for el, ok := it.Next(); ok; el, ok = it.Next() {
+				if result := rt.Call(fn, el); isError(result) {
+					return result
+				}
+			}
+			return nil
+		}},
+	},
+	{
+		"any",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("any", args, 2)
+			if err != nil {
+				return err
+			}
+			found, result := search(rt, it, fn, true)
+			if result != nil {
+				return result
+			}
+			return nativeBoolToBooleanObject(found != nil)
+		}},
+	},
+	{
+		"all",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("all", args, 2)
+			if err != nil {
+				return err
+			}
+			found, result := search(rt, it, fn, false)
+			if result != nil {
+				return result
+			}
+			return nativeBoolToBooleanObject(found == nil)
+		}},
+	},
+	{
+		"find",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("find", args, 2)
+			if err != nil {
+				return err
+			}
+			found, result := search(rt, it, fn, true)
+			if result != nil {
+				return result
+			}
+			return found
+		}},
+	},
+	{
+		"zip",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d, want at least 2", len(args))
+			}
+			iterators := make([]Iterator, len(args))
+			for i, arg := range args {
+				it, ok := arg.(Iterable)
+				if !ok {
+					return newError("arguments to `zip` must be iterable, got %s", arg.Type())
+				}
+				iterators[i] = it.Iterator()
+			}
+			result := &Array{}
+			for {
+				tuple := &Tuple{Elements: make([]Object, len(iterators))}
+				for i, it := range iterators {
+					el, ok := it.Next()
+					if !ok {
+						return result
+					}
+					tuple.Elements[i] = el
+				}
+				result.Elements = append(result.Elements, tuple)
+			}
+		}},
+	},
+	{
+		"flat_map",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			it, fn, err := walk("flat_map", args, 2)
+			if err != nil {
+				return err
+			}
+			result := &Array{}
+			for el, ok := it.Next(); ok; el, ok = it.Next() {
+				value := rt.Call(fn, el)
+				if isError(value) {
+					return value
+				}
+				inner, ok := value.(Iterable)
+				if !ok {
+					return newError("function passed to `flat_map` must return an iterable, got %s", value.Type())
+				}
+				innerIt := inner.Iterator()
+				for v, ok := innerIt.Next(); ok; v, ok = innerIt.Next() {
+					result.Elements = append(result.Elements, v)
+				}
+			}
+			return result
+		}},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("keys", args, HASH_OBJ); err != nil {
+				return err
+			}
+			result := &Array{}
+			for _, pair := range args[0].(*Hash).OrderedPairs() {
+				result.Elements = append(result.Elements, pair.Key)
+			}
+			return result
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("values", args, HASH_OBJ); err != nil {
+				return err
+			}
+			result := &Array{}
+			for _, pair := range args[0].(*Hash).OrderedPairs() {
+				result.Elements = append(result.Elements, pair.Value)
+			}
+			return result
+		}},
+	},
+	{
+		"entries",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("entries", args, HASH_OBJ); err != nil {
+				return err
+			}
+			result := &Array{}
+			for _, pair := range args[0].(*Hash).OrderedPairs() {
+				result.Elements = append(result.Elements, &Tuple{Elements: []Object{pair.Key, pair.Value}})
+			}
+			return result
+		}},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("first argument to `delete` must be HASH, got %s", args[0].Type())
+			}
+			if err := KeyError("hash key", args[1]); err != nil {
+				return newError("%s", err)
+			}
+			return hash.Without(args[1])
+		}},
+	},
+	{
+		"merge",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("merge", args, HASH_OBJ, HASH_OBJ); err != nil {
+				return err
+			}
+			result := args[0].(*Hash)
+			for _, pair := range args[1].(*Hash).OrderedPairs() {
+				result, _ = result.With(pair.Key, pair.Value)
+			}
+			return result
+		}},
+	},
+	{
+		"append!",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("first argument to `append!` must be ARRAY, got %s", args[0].Type())
+			}
+			if err := unchangeable("append!", arr); err != nil {
+				return err
+			}
+			arr.Append(args[1])
+			return arr
+		}},
+	},
+	{
+		"pop!",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("pop!", args, ARRAY_OBJ); err != nil {
+				return err
+			}
+			if err := unchangeable("pop!", args[0].(*Array)); err != nil {
+				return err
+			}
+			return args[0].(*Array).Pop()
+		}},
+	},
+	{
+		"insert!",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			if err := checkArgs("insert!", args[:2], ARRAY_OBJ, INTEGER_OBJ); err != nil {
+				return err
+			}
+			arr := args[0].(*Array)
+			if err := unchangeable("insert!", arr); err != nil {
+				return err
+			}
+			i, err := position("insert!", args[1].(*Integer).Value, len(arr.Elements)+1)
+			if err != nil {
+				return err
+			}
+			arr.Insert(i, args[2])
+			return arr
+		}},
+	},
+	{
+		"remove!",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("remove!", args, ARRAY_OBJ, INTEGER_OBJ); err != nil {
+				return err
+			}
+			arr := args[0].(*Array)
+			if err := unchangeable("remove!", arr); err != nil {
+				return err
+			}
+			i, err := position("remove!", args[1].(*Integer).Value, len(arr.Elements))
+			if err != nil {
+				return err
+			}
+			return arr.Remove(i)
+		}},
+	},
+	{
+		"reverse!",
+		&Builtin{Fn: func(args ...Object) Object {
+			if err := checkArgs("reverse!", args, ARRAY_OBJ); err != nil {
+				return err
+			}
+			arr := args[0].(*Array)
+			if err := unchangeable("reverse!", arr); err != nil {
+				return err
+			}
+			arr.Reverse()
+			return arr
+		}},
+	},
+	{
+		"sort!",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			arr, cmp, err := ordering(rt, "sort!", args)
+			if err != nil {
+				return err
+			}
+			if err := unchangeable("sort!", arr); err != nil {
+				return err
+			}
+			if err := arr.Sort(cmp); err != nil {
+				return newError("%s", err)
+			}
+			return arr
+		}},
+	},
+	{
+		"sort",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			arr, cmp, err := ordering(rt, "sort", args)
+			if err != nil {
+				return err
+			}
+			result := &Array{Elements: arr.Elements}
+			if err := result.Sort(cmp); err != nil {
+				return newError("%s", err)
+			}
+			return result
+		}},
+	},
+	{
+		"sort_by",
+		&Builtin{RuntimeFn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("first argument to `sort_by` must be ARRAY, got %s", args[0].Type())
+			}
+			if !isFunction(args[1]) {
+				return newError("second argument to `sort_by` must be a function, got %s", args[1].Type())
+			}
+			// each key is computed once and sorted along with its element
+			keyed := &Array{Elements: make([]Object, len(arr.Elements))}
+			for i, el := range arr.Elements {
+				key := rt.Call(args[1], el)
+				if isError(key) {
+					return key
+				}
+				keyed.Elements[i] = &Tuple{Elements: []Object{key, el}}
+			}
+			err := keyed.Sort(func(x, y Object) (int, error) {
+				return compare(x.(*Tuple).Elements[0], y.(*Tuple).Elements[0])
+			})
+			if err != nil {
+				return newError("%s", err)
+			}
+			result := &Array{Elements: make([]Object, len(keyed.Elements))}
+			for i, pair := range keyed.Elements {
+				result.Elements[i] = pair.(*Tuple).Elements[1]
+			}
+			return result
+		}},
+	},
+}
+
+// unchangeable reports an error when arr is a hash key or set element, which
+// the builtin name must not change in place.
+func unchangeable(name string, arr *Array) *Error {
+	if !arr.frozen {
+		return nil
+	}
+	return newError("`%s` cannot change an array used as a hash key or set element", name)
+}
+
+// ordering checks the arguments of sort and sort!, an array and an optional
+// comparison function, and returns the array with how to order its elements:
+// by compare, or by calling the function with two elements, which must return
+// a negative integer, zero or a positive integer.
+func ordering(rt Runtime, name string, args []Object) (*Array, func(x, y Object) (int, error), *Error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, newError("%s to `%s` must be ARRAY, got %s", argument(0, len(args)), name, args[0].Type())
+	}
+	if len(args) == 1 {
+		return arr, compare, nil
+	}
+	fn := args[1]
+	if !isFunction(fn) {
+		return nil, nil, newError("second argument to `%s` must be a function, got %s", name, fn.Type())
+	}
+	return arr, func(x, y Object) (int, error) {
+		switch result := rt.Call(fn, x, y).(type) {
+		case *Integer:
+			switch {
+			case result.Value < 0:
+				return -1, nil
+			case result.Value > 0:
+				return 1, nil
+			}
+			return 0, nil
+		case *Error:
+			return 0, errors.New(result.Message)
+		default:
+			return 0, fmt.Errorf("comparison function for `%s` must return INTEGER, got %s", name, result.Type())
+		}
+	}, nil
+}
+
+// setOperation checks that a builtin got two sets before handing them to op.
+func setOperation(name string, op func(a, b *Set) Object) BuiltinFunction {
+	return func(args ...Object) Object {
+		if len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=2", len(args))
+		}
+		for _, arg := range args {
+			if arg.Type() != SET_OBJ {
+				return newError("arguments to `%s` must be SET, got %s", name, arg.Type())
+			}
+		}
+		return op(args[0].(*Set), args[1].(*Set))
+	}
+}
+
+// checkArgs reports a wrong number of arguments, or an argument that is not
+// of the type the builtin name wants in its position.
+func checkArgs(name string, args []Object, want ...ObjectType) *Error {
+	if len(args) != len(want) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(want))
+	}
+	for i, arg := range args {
+		if arg.Type() != want[i] {
+			return newError("%s to `%s` must be %s, got %s", argument(i, len(args)), name, want[i], arg.Type())
+		}
+	}
+	return nil
+}
+
+// position turns an index into one of n positions, counting negative indices
+// from the end.
+func position(name string, index int64, n int) (int, *Error) {
+	i := index
+	if i < 0 {
+		i += int64(n)
+	}
+	if i < 0 || i >= int64(n) {
+		return 0, newError("index %d out of range for `%s`", index, name)
+	}
+	return int(i), nil
+}
+
+// argument names the i-th of n arguments in error messages.
+func argument(i, n int) string {
+	if n == 1 {
+		return "argument"
+	}
+	return []string{"first", "second", "third"}[i] + " argument"
+}
+
+// stringFunction turns a function on strings into a builtin.
+func stringFunction(name string, fn func(string) string) BuiltinFunction {
+	return func(args ...Object) Object {
+		if err := checkArgs(name, args, STRING_OBJ); err != nil {
+			return err
+		}
+		return &String{Value: fn(args[0].(*String).Value)}
+	}
+}
+
+// stringPredicate turns a test of one string against another into a builtin.
+func stringPredicate(name string, fn func(s, substr string) bool) BuiltinFunction {
+	return func(args ...Object) Object {
+		if err := checkArgs(name, args, STRING_OBJ, STRING_OBJ); err != nil {
+			return err
+		}
+		return nativeBoolToBooleanObject(fn(args[0].(*String).Value, args[1].(*String).Value))
+	}
+}
+
+// maxStringLength bounds the strings repeat and the pad builtins build, so
+// that a huge count is an error rather than a crash.
+const maxStringLength = 1 << 30
+
+// pad builds pad_left and pad_right, which widen a string to a number of
+// characters with spaces or with the character given as third argument.
+func pad(name string, join func(s, padding string) string) BuiltinFunction {
+	return func(args ...Object) Object {
+		fill := " "
+		if len(args) == 3 {
+			str, ok := args[2].(*String)
+			if !ok || utf8.RuneCountInString(str.Value) != 1 {
+				return newError("third argument to `%s` must be a single character, got %s", name, args[2].Inspect())
+			}
+			fill = str.Value
+			args = args[:2]
+		}
+		if err := checkArgs(name, args, STRING_OBJ, INTEGER_OBJ); err != nil {
+			return err
+		}
+		str := args[0].(*String).Value
+		width := args[1].(*Integer).Value
+		if width > maxStringLength/int64(len(fill)) {
+			return newError("result of `%s` would be longer than %d bytes", name, maxStringLength)
+		}
+		missing := int(width) - utf8.RuneCountInString(str)
+		if missing <= 0 {
+			return args[0]
+		}
+		return &String{Value: join(str, strings.Repeat(fill, missing))}
+	}
+}
+
+// walk checks the arguments of a builtin that calls its last argument for
+// the elements of its first, and returns an iterator over those elements.
+func walk(name string, args []Object, want int) (Iterator, Object, *Error) {
+	if len(args) != want {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+	iterable, ok := args[0].(Iterable)
+	if !ok {
+		return nil, nil, newError("first argument to `%s` must be iterable, got %s", name, args[0].Type())
+	}
+	fn := args[want-1]
+	if !isFunction(fn) {
+		return nil, nil, newError("%s to `%s` must be a function, got %s", argument(want-1, want), name, fn.Type())
+	}
+	return iterable.Iterator(), fn, nil
+}
+
+func isFunction(obj Object) bool {
+	switch obj.Type() {
+	case FUNCTION_OBJ, CLOSURE_OBJ, BUILTIN_OBJ:
+		return true
+	}
+	return false
+}
+
+// search returns the first element for which fn's truthiness is want, or an
+// error returned by fn as its second result.
+func search(rt Runtime, it Iterator, fn Object, want bool) (Object, Object) {
+	for el, ok := it.Next(); ok; el, ok = it.Next() {
+		result := rt.Call(fn, el)
+		if isError(result) {
+			return nil, result
+		}
+		if isTruthy(result) == want {
+			return el, nil
+		}
+	}
+	return nil, nil
+}
+
+// isTruthy matches the conditions of both engines: only false and null are
+// false.
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	}
+	return true
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+	return &Array{Elements: elements}
+}
+
+// TRUE, FALSE and NULL are shared by both engines, which compare booleans by
+// identity.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
+func nativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,106 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+const CHANNEL_OBJ = "CHANNEL"
+
+// maxChannelSize bounds the buffer of a channel, which Go allocates up front.
+const maxChannelSize = 1 << 20
+
+// Channel is a Go channel of Monkey values. Unlike a bare Go channel, sending
+// on or closing an already closed Channel is reported as an error instead of
+// panicking.
+type Channel struct {
+	Chan chan Object
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewChannel(size int) *Channel {
+	return &Channel{Chan: make(chan Object, size)}
+}
+
+func (c *Channel) Type() ObjectType {
+	return CHANNEL_OBJ
+}
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("channel(%d)", cap(c.Chan))
+}
+
+func (c *Channel) Send(value Object) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = errors.New("send on closed channel")
+		}
+	}()
+	c.Chan <- value
+	return nil
+}
+
+// Recv blocks until a value arrives. It reports false once the channel is
+// closed and drained.
+func (c *Channel) Recv() (Object, bool) {
+	value, ok := <-c.Chan
+	return value, ok
+}
+
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("close of closed channel")
+	}
+	c.closed = true
+	close(c.Chan)
+	return nil
+}
+
+// SelectCase is one arm of a `select`. Value is the object to send, or nil
+// when the arm receives.
+type SelectCase struct {
+	Channel *Channel
+	Value   Object
+}
+
+// Select waits until one of the cases can proceed and returns its index along
+// with the received value, which is nil for sends and for closed channels.
+// With hasDefault set it never blocks and returns len(cases) when no case is
+// ready.
+func Select(cases []SelectCase, hasDefault bool) (chosen int, received Object, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errors.New("send on closed channel")
+		}
+	}()
+
+	selectCases := make([]reflect.SelectCase, 0, len(cases)+1)
+	for _, c := range cases {
+		if c.Value != nil {
+			selectCases = append(selectCases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(c.Channel.Chan),
+				Send: reflect.ValueOf(&c.Value).Elem(),
+			})
+		} else {
+			selectCases = append(selectCases, reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(c.Channel.Chan),
+			})
+		}
+	}
+	if hasDefault {
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	chosen, value, ok := reflect.Select(selectCases)
+	if chosen < len(cases) && cases[chosen].Value == nil && ok {
+		received = value.Interface().(Object)
+	}
+	return chosen, received, nil
+}
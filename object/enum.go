@@ -0,0 +1,102 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+const VARIANT_OBJ = "VARIANT"
+
+// Variant is a value of an enum: the tag of one of its variants together
+// with the values of that variant's fields.
+type Variant struct {
+	Enum   string
+	Tag    string
+	Fields []string
+	Values []Object
+}
+
+func (v *Variant) Type() ObjectType {
+	return VARIANT_OBJ
+}
+func (v *Variant) Inspect() string {
+	if len(v.Values) == 0 {
+		return v.Tag
+	}
+	var out bytes.Buffer
+
+	var values []string
+	for _, val := range v.Values {
+		values = append(values, val.Inspect())
+	}
+
+	out.WriteString(v.Tag)
+	out.WriteString("(")
+	out.WriteString(strings.Join(values, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// HashKey combines the enum, the tag and the payload.
+func (v *Variant) HashKey() HashKey {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s.%s", v.Enum, v.Tag)
+	writeHashKeys(h, v.Values)
+	return HashKey{Type: v.Type(), Value: h.Sum64()}
+}
+
+// Equal reports whether both variants have the same enum, tag and payload.
+func (v *Variant) Equal(other *Variant) bool {
+	if v.Enum != other.Enum || v.Tag != other.Tag || len(v.Values) != len(other.Values) {
+		return false
+	}
+	for i := range v.Values {
+		if !Equal(v.Values[i], other.Values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Index returns a field of the variant, by position or by name.
+func (v *Variant) Index(index Object) (Object, error) {
+	switch index := index.(type) {
+	case *Integer:
+		i := index.Value
+		if i < 0 {
+			i += int64(len(v.Values))
+		}
+		if i < 0 || i >= int64(len(v.Values)) {
+			return NULL, nil
+		}
+		return v.Values[i], nil
+	case *String:
+		for i, field := range v.Fields {
+			if field == index.Value {
+				return v.Values[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%s has no field %s", v.Tag, index.Value)
+	}
+	return nil, fmt.Errorf("unusable as variant index: %s", index.Type())
+}
+
+// NewVariant returns what the name of an enum variant is bound to: the
+// variant itself when it has no fields, otherwise a builtin constructing one
+// from as many arguments as there are fields.
+func NewVariant(enum, tag string, fields []string) Object {
+	if len(fields) == 0 {
+		return &Variant{Enum: enum, Tag: tag}
+	}
+	return &Builtin{Fn: func(args ...Object) Object {
+		if len(args) != len(fields) {
+			return newError("wrong number of arguments to `%s`. got=%d, want=%d", tag, len(args), len(fields))
+		}
+		values := make([]Object, len(args))
+		copy(values, args)
+		return &Variant{Enum: enum, Tag: tag, Fields: fields, Values: values}
+	}}
+}
@@ -1,28 +1,84 @@
 package object
 
+import "sync"
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	c := make(map[string]bool)
+	return &Environment{store: s, consts: c, outer: nil, methods: NewMethods()}
 }
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	s := make(map[string]Object)
+	c := make(map[string]bool)
+	return &Environment{store: s, consts: c, outer: outer, methods: outer.methods}
+}
+
+// NewGeneratorEnvironment encloses outer for the body of a running generator.
+// `yield` anywhere in that body suspends through the given function.
+func NewGeneratorEnvironment(outer *Environment, yield func(Object)) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.yield = yield
 	return env
 }
 
+// Environment may be shared between spawned functions, so every binding is
+// read and written under a lock.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	mu     sync.RWMutex
+	store  map[string]Object
+	consts map[string]bool
+	outer  *Environment
+	yield  func(Object)
+	// methods is shared by every environment enclosed in the same root
+	methods *Methods
+}
+
+// Methods returns the impls declared by the program env belongs to.
+func (e *Environment) Methods() *Methods {
+	return e.methods
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	e.mu.RLock()
 	o, ok := e.store[name]
+	e.mu.RUnlock()
 	if !ok && e.outer != nil {
 		o, ok = e.outer.Get(name)
 	}
 	return o, ok
 }
 func (e *Environment) Set(name string, val Object) Object {
+	e.mu.Lock()
+	e.store[name] = val
+	delete(e.consts, name)
+	e.mu.Unlock()
+	return val
+}
+
+// SetConst binds name like Set and marks it as const in this environment.
+func (e *Environment) SetConst(name string, val Object) Object {
+	e.mu.Lock()
 	e.store[name] = val
+	e.consts[name] = true
+	e.mu.Unlock()
 	return val
 }
+
+// IsConst reports whether name is bound by `const` in this environment
+// itself. Enclosed environments may shadow it.
+func (e *Environment) IsConst(name string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.consts[name]
+}
+
+// Yield returns the suspend function of the innermost enclosing generator.
+func (e *Environment) Yield() (func(Object), bool) {
+	if e.yield != nil {
+		return e.yield, true
+	}
+	if e.outer != nil {
+		return e.outer.Yield()
+	}
+	return nil, false
+}
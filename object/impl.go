@@ -0,0 +1,101 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ImplMethods are the names an impl may define. The operators are called with
+// both operands, to_string and len with the value alone.
+var ImplMethods = map[string]bool{
+	"+":         true,
+	"-":         true,
+	"*":         true,
+	"/":         true,
+	"==":        true,
+	"to_string": true,
+	"len":       true,
+}
+
+// Runtime lets a builtin call back into the engine running it.
+type Runtime interface {
+	// Call applies fn to args. Failures are returned as an *Error.
+	Call(fn Object, args ...Object) Object
+	// Method returns the function an impl attached to the type of obj.
+	Method(obj Object, name string) (Object, bool)
+}
+
+// Methods is the dispatch table filled by impl statements. It belongs to one
+// running program and is shared with everything that program spawns.
+type Methods struct {
+	mu    sync.RWMutex
+	impls map[string]map[string]Object
+}
+
+func NewMethods() *Methods {
+	return &Methods{impls: make(map[string]map[string]Object)}
+}
+
+// Impl attaches the functions in methods, keyed by method name, to tag.
+// Implementing a method again replaces the earlier function.
+func (m *Methods) Impl(tag string, methods *Hash) error {
+	for _, pair := range methods.OrderedPairs() {
+		name, ok := pair.Key.(*String)
+		if !ok || !ImplMethods[name.Value] {
+			return fmt.Errorf("cannot implement %s for %s", pair.Key.Inspect(), tag)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	impl, ok := m.impls[tag]
+	if !ok {
+		impl = make(map[string]Object)
+		m.impls[tag] = impl
+	}
+	for _, pair := range methods.OrderedPairs() {
+		impl[pair.Key.(*String).Value] = pair.Value
+	}
+	return nil
+}
+
+// Lookup returns the function implementing name for the type of obj.
+func (m *Methods) Lookup(obj Object, name string) (Object, bool) {
+	tag := TypeTag(obj)
+	if tag == "" {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fn, ok := m.impls[tag][name]
+	return fn, ok
+}
+
+// TypeTag is the name impls for obj are attached to: the tag given to a hash
+// by `new`, or the enum of a variant. Other values have no tag.
+func TypeTag(obj Object) string {
+	switch obj := obj.(type) {
+	case *Hash:
+		return obj.Tag
+	case *Variant:
+		return obj.Enum
+	}
+	return ""
+}
+
+// ToString converts obj for printing, through its to_string impl if it has
+// one. Strings are returned as they are.
+func ToString(rt Runtime, obj Object) Object {
+	if fn, ok := rt.Method(obj, "to_string"); ok {
+		result := rt.Call(fn, obj)
+		switch result.(type) {
+		case *String, *Error:
+			return result
+		}
+		return newError("to_string must return STRING, got %s", result.Type())
+	}
+	if str, ok := obj.(*String); ok {
+		return str
+	}
+	return &String{Value: obj.Inspect()}
+}
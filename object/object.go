@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"monkey/ast"
+	"monkey/code"
 	"strings"
+	"sync/atomic"
 )
 
 type ObjectType string
@@ -21,6 +24,11 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	GENERATOR_OBJ         = "GENERATOR"
+	ITERATOR_OBJ          = "ITERATOR"
 )
 
 type Object interface {
@@ -82,9 +90,10 @@ func (e *Error) Type() ObjectType {
 }
 
 type Function struct {
-	Parameters []*ast.Identifier
-	Body       *ast.BlockStatement
-	Env        *Environment
+	Parameters  []*ast.Identifier
+	Body        *ast.BlockStatement
+	Env         *Environment
+	IsGenerator bool
 }
 
 func (f *Function) Inspect() string {
@@ -96,6 +105,9 @@ func (f *Function) Inspect() string {
 	}
 
 	out.WriteString("fn")
+	if f.IsGenerator {
+		out.WriteString("*")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -110,6 +122,7 @@ func (f *Function) Type() ObjectType {
 
 type String struct {
 	Value string
+	hash  uint64 // cached by HashKey; zero until first computed
 }
 
 func (s *String) Inspect() string {
@@ -122,6 +135,17 @@ func (s *String) Type() ObjectType {
 type BuiltinFunction func(args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
+	// RuntimeFn is used instead of Fn by builtins that call back into the
+	// engine, such as those dispatching to impls.
+	RuntimeFn func(rt Runtime, args ...Object) Object
+}
+
+// Call runs the builtin on behalf of rt.
+func (b *Builtin) Call(rt Runtime, args ...Object) Object {
+	if b.RuntimeFn != nil {
+		return b.RuntimeFn(rt, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Inspect() string {
@@ -133,6 +157,12 @@ func (b *Builtin) Type() ObjectType {
 
 type Array struct {
 	Elements []Object
+	// shared is set on arrays made by Push and Rest, whose Elements may
+	// share a backing array with other arrays.
+	shared *backing
+	// frozen is set on the copies of arrays stored as hash keys and set
+	// elements, which must not change once hashed.
+	frozen bool
 }
 
 func (a *Array) Inspect() string {
@@ -170,18 +200,38 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
+
+// HashKey hashes the string once and reuses the result. Strings may be shared
+// between goroutines, so the cache is read and written atomically.
 func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	_, _ = h.Write([]byte(s.Value))
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	hash := atomic.LoadUint64(&s.hash)
+	if hash == 0 {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(s.Value))
+		hash = h.Sum64()
+		atomic.StoreUint64(&s.hash, hash)
+	}
+	return HashKey{Type: s.Type(), Value: hash}
 }
 
 type HashPair struct {
 	Key   Object
 	Value Object
 }
+
+// Hash maps hashable keys to values. Pairs are kept in the order their keys
+// were first added so that Inspect and iteration are predictable. Both the
+// pairs and the index over their keys are persistent, so With and Without
+// share everything they leave unchanged with the original hash.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	Tag   string     // set by `new`; selects the impl the hash dispatches to
+	pairs pairVector // deleted pairs are left as zero HashPairs
+	index *hamt      // positions in pairs by the HashKey of the key
+	live  int        // pairs not deleted
+}
+
+func NewHash() *Hash {
+	return &Hash{}
 }
 
 func (h *Hash) Type() ObjectType {
@@ -191,9 +241,12 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
+	if h.Tag != "" {
+		out.WriteString(h.Tag + " ")
+	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString("}")
@@ -201,6 +254,448 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Put sets the value of key. A new key goes after the existing ones, while
+// an existing key keeps its place. It reports false when key cannot be
+// hashed. Put changes h itself, and is meant for building a new hash.
+func (h *Hash) Put(key, value Object) bool {
+	hashed, i, ok := h.find(key)
+	if !ok {
+		return false
+	}
+	if i < 0 {
+		positions := h.index.get(hashed)
+		h.index = h.index.put(hashed, append(positions[:len(positions):len(positions)], h.pairs.count))
+		h.pairs = h.pairs.push(HashPair{Key: snapshot(key), Value: value})
+		h.live++
+	} else {
+		h.pairs = h.pairs.set(i, HashPair{Key: h.pairs.get(i).Key, Value: value})
+	}
+	return true
+}
+
+// With returns a copy of h with key set to value. It reports false when key
+// cannot be hashed.
+func (h *Hash) With(key, value Object) (*Hash, bool) {
+	result := *h
+	return &result, result.Put(key, value)
+}
+
+// Without returns a copy of h without key.
+func (h *Hash) Without(key Object) *Hash {
+	result := *h
+	hashed, i, _ := h.find(key)
+	if i < 0 {
+		return &result
+	}
+	var positions []int
+	for _, p := range h.index.get(hashed) {
+		if p != i {
+			positions = append(positions, p)
+		}
+	}
+	result.index = h.index.put(hashed, positions)
+	result.pairs = h.pairs.set(i, HashPair{})
+	result.live--
+
+	// rebuild once most of the pairs are deleted ones, which keeps the cost
+	// of skipping them proportional to the live pairs
+	if result.pairs.count > trieWidth && result.live < result.pairs.count/2 {
+		compacted := NewHash()
+		compacted.Tag = h.Tag
+		for _, pair := range result.OrderedPairs() {
+			compacted.Put(pair.Key, pair.Value)
+		}
+		return compacted
+	}
+	return &result
+}
+
+// Get returns the value of key. It reports false when the key is missing or
+// cannot be hashed.
+func (h *Hash) Get(key Object) (Object, bool) {
+	_, i, _ := h.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return h.pairs.get(i).Value, true
+}
+
+func (h *Hash) find(key Object) (HashKey, int, bool) {
+	return locate(key, h.index.get, func(i int) Object { return h.pairs.get(i).Key })
+}
+
+func (h *Hash) Len() int {
+	return h.live
+}
+
+// OrderedPairs returns the pairs in insertion order.
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, h.live)
+	for i := 0; i < h.pairs.count; i++ {
+		if pair := h.pairs.get(i); pair.Key != nil {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// Tagged returns a copy of the hash with the given tag.
+func (h *Hash) Tagged(tag string) *Hash {
+	result := *h
+	result.Tag = tag
+	return &result
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
+
+// HashKey combines the keys of the elements, so equal arrays share a key.
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	writeHashKeys(h, a.Elements)
+	return HashKey{Type: a.Type(), Value: h.Sum64()}
+}
+
+// HashKey combines the tag and the pairs. Like Equal it ignores the order of
+// the pairs.
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.OrderedPairs() {
+		p := fnv.New64a()
+		writeHashKeys(p, []Object{pair.Key, pair.Value})
+		sum += p.Sum64()
+	}
+	k := fnv.New64a()
+	_, _ = fmt.Fprintf(k, "%s|%d", h.Tag, sum)
+	return HashKey{Type: h.Type(), Value: k.Sum64()}
+}
+
+// writeHashKeys writes the keys of values to w for a compound HashKey. Values
+// that cannot be hashed contribute their identity; KeyError keeps them from
+// being used as keys.
+func writeHashKeys(w io.Writer, values []Object) {
+	for _, val := range values {
+		if hashable, ok := val.(Hashable); ok {
+			key := hashable.HashKey()
+			_, _ = fmt.Fprintf(w, "|%s:%d", key.Type, key.Value)
+		} else {
+			_, _ = fmt.Fprintf(w, "|%p", val)
+		}
+	}
+}
+
+// Unhashable returns the value that keeps obj from being a hash key or a set
+// element: obj itself, or the first such value inside an array, hash, tuple
+// or variant. It returns nil when obj can be hashed.
+func Unhashable(obj Object) Object {
+	var inner []Object
+	switch obj := obj.(type) {
+	case *Array:
+		inner = obj.Elements
+	case *Tuple:
+		inner = obj.Elements
+	case *Variant:
+		inner = obj.Values
+	case *Hash:
+		for _, pair := range obj.OrderedPairs() {
+			inner = append(inner, pair.Key, pair.Value)
+		}
+	case Hashable:
+		return nil
+	default:
+		return obj
+	}
+	for _, val := range inner {
+		if bad := Unhashable(val); bad != nil {
+			return bad
+		}
+	}
+	return nil
+}
+
+// KeyError explains why obj cannot be used as what, a hash key or a set
+// element. It returns nil when obj can be hashed.
+func KeyError(what string, obj Object) error {
+	bad := Unhashable(obj)
+	switch {
+	case bad == nil:
+		return nil
+	case bad == obj:
+		return fmt.Errorf("unusable as %s: %s", what, obj.Type())
+	}
+	return fmt.Errorf("unusable as %s: %s containing %s", what, obj.Type(), bad.Type())
+}
+
+// buckets indexes the elements of a set by HashKey. Each bucket lists the
+// positions of the values with that HashKey, so values whose hashes collide
+// are kept apart and told apart by Equal.
+type buckets map[HashKey][]int
+
+func (b buckets) find(key Object, at func(int) Object) (HashKey, int, bool) {
+	return locate(key, func(hashed HashKey) []int { return b[hashed] }, at)
+}
+
+// locate returns the HashKey of key and the position of the equal value, or
+// -1 when there is none. positions lists the candidates for a HashKey and at
+// reads the value at a position. It reports false when key cannot be hashed.
+func locate(key Object, positions func(HashKey) []int, at func(int) Object) (HashKey, int, bool) {
+	if Unhashable(key) != nil {
+		return HashKey{}, -1, false
+	}
+	hashed := key.(Hashable).HashKey()
+	for _, i := range positions(hashed) {
+		if Equal(at(i), key) {
+			return hashed, i, true
+		}
+	}
+	return hashed, -1, true
+}
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	IsGenerator   bool
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Iterator hands out the elements of a collection one at a time. Next
+// reports false once there is nothing left.
+type Iterator interface {
+	Object
+	Next() (Object, bool)
+}
+
+// Iterable is implemented by everything a `for` loop can walk over.
+type Iterable interface {
+	Iterator() Iterator
+}
+
+type ArrayIterator struct {
+	array *Array
+	index int
+}
+
+func (ai *ArrayIterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+func (ai *ArrayIterator) Inspect() string {
+	return "iterator"
+}
+func (ai *ArrayIterator) Next() (Object, bool) {
+	if ai.index >= len(ai.array.Elements) {
+		return nil, false
+	}
+	el := ai.array.Elements[ai.index]
+	ai.index++
+	return el, true
+}
+
+func (a *Array) Iterator() Iterator {
+	return &ArrayIterator{array: a}
+}
+
+// Iterator walks the keys of the hash.
+func (h *Hash) Iterator() Iterator {
+	keys := make([]Object, 0, h.live)
+	for _, pair := range h.OrderedPairs() {
+		keys = append(keys, pair.Key)
+	}
+	return &ArrayIterator{array: &Array{Elements: keys}}
+}
+
+// Pairs iterates over the key and value of every pair in a hash, or the index
+// and element of every entry in an array, as two element arrays.
+func Pairs(obj Object) (Iterator, bool) {
+	var pairs []Object
+	switch obj := obj.(type) {
+	case *Hash:
+		for _, pair := range obj.OrderedPairs() {
+			pairs = append(pairs, &Array{Elements: []Object{pair.Key, pair.Value}})
+		}
+	case *Array:
+		for i, el := range obj.Elements {
+			pairs = append(pairs, &Array{Elements: []Object{&Integer{Value: int64(i)}, el}})
+		}
+	default:
+		return nil, false
+	}
+	return &ArrayIterator{array: &Array{Elements: pairs}}, true
+}
+
+// Contains implements `element in container`: whether an array holds an equal
+// element, a hash has the key, a set or range has the element, or a string
+// has the substring.
+func Contains(container, element Object) (bool, error) {
+	switch container := container.(type) {
+	case *Array:
+		for _, el := range container.Elements {
+			if Equal(el, element) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *Tuple:
+		for _, el := range container.Elements {
+			if Equal(el, element) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *Hash:
+		if err := KeyError("hash key", element); err != nil {
+			return false, err
+		}
+		_, ok := container.Get(element)
+		return ok, nil
+	case *Set:
+		if err := KeyError("set element", element); err != nil {
+			return false, err
+		}
+		return container.Has(element), nil
+	case *Range:
+		if n, ok := element.(*Integer); ok {
+			return n.Value >= container.Start && n.Value < container.End, nil
+		}
+	case *String:
+		if s, ok := element.(*String); ok {
+			return strings.Contains(container.Value, s.Value), nil
+		}
+	}
+	return false, fmt.Errorf("unknown operator: %s in %s", element.Type(), container.Type())
+}
+
+// Equal implements `==`. Strings and collections are compared by content, the
+// pairs of hashes and the elements of sets regardless of order. Values of
+// different types are never equal, and functions, channels and the like are
+// equal only to themselves.
+func Equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Variant:
+		b, ok := b.(*Variant)
+		return ok && a.Equal(b)
+	case *Tuple:
+		b, ok := b.(*Tuple)
+		return ok && a.Equal(b)
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && equalElements(a.Elements, b.Elements)
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Tag != b.Tag || a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.OrderedPairs() {
+			value, ok := b.Get(pair.Key)
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+		return true
+	case *Set:
+		b, ok := b.(*Set)
+		return ok && a.Len() == b.Len() && a.IsSubset(b)
+	case *Range:
+		b, ok := b.(*Range)
+		return ok && a.Start == b.Start && a.End == b.End
+	}
+	return false
+}
+
+func equalElements(a, b []Object) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// StringIterator hands out the characters of a string as one-character
+// strings.
+type StringIterator struct {
+	runes []rune
+	index int
+}
+
+func (si *StringIterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+func (si *StringIterator) Inspect() string {
+	return "iterator"
+}
+func (si *StringIterator) Next() (Object, bool) {
+	if si.index >= len(si.runes) {
+		return nil, false
+	}
+	ch := si.runes[si.index]
+	si.index++
+	return &String{Value: string(ch)}, true
+}
+
+func (s *String) Iterator() Iterator {
+	return &StringIterator{runes: []rune(s.Value)}
+}
+
+// Generator is the value returned by calling a `fn*`. Each engine supplies
+// Resume, which runs the suspended body up to its next `yield` and reports
+// false once the body has returned. An *Error produced by the body is handed
+// out as a value and finishes the generator.
+type Generator struct {
+	Resume func() (Object, bool)
+	done   bool
+}
+
+func (g *Generator) Type() ObjectType {
+	return GENERATOR_OBJ
+}
+func (g *Generator) Inspect() string {
+	return "generator"
+}
+func (g *Generator) Next() (Object, bool) {
+	if g.done {
+		return nil, false
+	}
+	value, ok := g.Resume()
+	if !ok || value.Type() == ERROR_OBJ {
+		g.done = true
+	}
+	return value, ok
+}
+func (g *Generator) Iterator() Iterator {
+	return g
+}
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello"}
@@ -15,7 +19,329 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with same content have differnt hash keys")
 	}
 
+	if hello1.HashKey() != hello1.HashKey() {
+		t.Errorf("cached hash key differs from the first one")
+	}
 	if hello1.HashKey() == diff1.HashKey() {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestSetOperations(t *testing.T) {
+	a := NewSet()
+	b := NewSet()
+	for _, v := range []int64{1, 2, 3, 2} {
+		a.Add(&Integer{Value: v})
+	}
+	for _, v := range []int64{3, 4} {
+		b.Add(&Integer{Value: v})
+	}
+	if !a.Add(&String{Value: "x"}) || a.Add(&Array{Elements: []Object{&Builtin{}}}) {
+		t.Errorf("Add did not report which elements are hashable")
+	}
+
+	tests := []struct {
+		set      *Set
+		expected string
+	}{
+		{a, `#{1, 2, 3, x}`},
+		{a.Union(b), `#{1, 2, 3, x, 4}`},
+		{a.Intersection(b), `#{3}`},
+		{a.Difference(b), `#{1, 2, x}`},
+		{b.Difference(a), `#{4}`},
+	}
+	for _, tt := range tests {
+		if tt.set.Inspect() != tt.expected {
+			t.Errorf("wrong set. want=%s, got=%s", tt.expected, tt.set.Inspect())
+		}
+	}
+
+	if !a.Intersection(b).IsSubset(b) || a.IsSubset(b) {
+		t.Errorf("wrong IsSubset result")
+	}
+}
+
+func TestHashInsertionOrder(t *testing.T) {
+	h := NewHash()
+	for i, key := range []string{"c", "a", "b", "a"} {
+		h.Put(&String{Value: key}, &Integer{Value: int64(i)})
+	}
+	if h.Put(&Builtin{}, NULL) {
+		t.Errorf("Put did not report an unhashable key")
+	}
+	if h.Inspect() != `{c: 0, a: 3, b: 2}` {
+		t.Errorf("wrong hash. got=%s", h.Inspect())
+	}
+
+	var keys []string
+	it := h.Iterator()
+	for key, ok := it.Next(); ok; key, ok = it.Next() {
+		keys = append(keys, key.Inspect())
+	}
+	if strings.Join(keys, "") != "cab" {
+		t.Errorf("wrong iteration order. got=%v", keys)
+	}
+}
+
+// collider is a hashable value whose HashKey is the same for every instance.
+type collider struct{ name string }
+
+func (c *collider) Type() ObjectType { return "COLLIDER" }
+func (c *collider) Inspect() string  { return c.name }
+func (c *collider) HashKey() HashKey { return HashKey{Type: "COLLIDER", Value: 1} }
+
+func TestCollidingHashKeys(t *testing.T) {
+	a, b := &collider{name: "a"}, &collider{name: "b"}
+
+	h := NewHash()
+	h.Put(a, &Integer{Value: 1})
+	h.Put(b, &Integer{Value: 2})
+	h.Put(a, &Integer{Value: 3})
+	if h.Inspect() != `{a: 3, b: 2}` {
+		t.Errorf("colliding keys overwrote each other. got=%s", h.Inspect())
+	}
+	if value, ok := h.Get(b); !ok || value.Inspect() != "2" {
+		t.Errorf("wrong value for b. got=%v", value)
+	}
+	if _, ok := h.Get(&collider{name: "c"}); ok {
+		t.Errorf("found a key that was never added")
+	}
+
+	s := NewSet()
+	s.Add(a)
+	s.Add(b)
+	if s.Len() != 2 || !s.Has(b) || s.Has(&collider{name: "c"}) {
+		t.Errorf("wrong set of colliding elements. got=%s", s.Inspect())
+	}
+}
+
+func TestVariantEqualityAndHashing(t *testing.T) {
+	circle := NewVariant("Shape", "Circle", []string{"r"}).(*Builtin)
+	one := circle.Fn(&Integer{Value: 1}).(*Variant)
+	otherOne := circle.Fn(&Integer{Value: 1}).(*Variant)
+	two := circle.Fn(&Integer{Value: 2}).(*Variant)
+	red := NewVariant("Color", "Red", nil).(*Variant)
+	otherRed := NewVariant("Color", "Red", nil).(*Variant)
+	state := NewVariant("State", "Red", nil).(*Variant)
+
+	if !one.Equal(otherOne) || one.HashKey() != otherOne.HashKey() {
+		t.Errorf("variants with equal payloads differ")
+	}
+	if one.Equal(two) || one.HashKey() == two.HashKey() {
+		t.Errorf("variants with different payloads are the same")
+	}
+	if !red.Equal(otherRed) || red.HashKey() != otherRed.HashKey() {
+		t.Errorf("variants without payloads differ")
+	}
+	if red.Equal(state) || red.HashKey() == state.HashKey() {
+		t.Errorf("variants of different enums are the same")
+	}
+
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	if !circle.Fn(array).(*Variant).Equal(circle.Fn(&Array{Elements: []Object{&Integer{Value: 1}}}).(*Variant)) {
+		t.Errorf("unhashable payloads are not compared by value")
+	}
+	if circle.Fn(array).(*Variant).Equal(circle.Fn(&Array{}).(*Variant)) {
+		t.Errorf("different unhashable payloads are the same")
+	}
+}
+
+func TestTupleEqualityAndHashing(t *testing.T) {
+	one := &Tuple{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	otherOne := &Tuple{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	swapped := &Tuple{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+	nested := &Tuple{Elements: []Object{one}}
+
+	if !one.Equal(otherOne) || one.HashKey() != otherOne.HashKey() {
+		t.Errorf("tuples with equal elements differ")
+	}
+	if one.Equal(swapped) || one.HashKey() == swapped.HashKey() {
+		t.Errorf("tuples with elements in a different order are the same")
+	}
+	if !nested.Equal(&Tuple{Elements: []Object{otherOne}}) {
+		t.Errorf("nested tuples with equal elements differ")
+	}
+	if one.Equal(&Tuple{Elements: one.Elements[:1]}) {
+		t.Errorf("tuples of different lengths are the same")
+	}
+}
+
+func TestPersistentHash(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 2000; i++ {
+		h.Put(&Integer{Value: int64(i)}, &Integer{Value: int64(i * 2)})
+	}
+	before := h.Inspect()
+
+	updated, _ := h.With(&Integer{Value: 7}, &String{Value: "x"})
+	added, _ := updated.With(&String{Value: "new"}, TRUE)
+	removed := added
+	for i := 0; i < 1500; i++ {
+		removed = removed.Without(&Integer{Value: int64(i)})
+	}
+
+	if h.Inspect() != before || h.Len() != 2000 {
+		t.Fatalf("updates changed the original hash")
+	}
+	if v, _ := updated.Get(&Integer{Value: 7}); v.Inspect() != "x" || updated.Len() != 2000 {
+		t.Errorf("wrong updated hash. got=%v, len=%d", v, updated.Len())
+	}
+	if removed.Len() != 501 {
+		t.Errorf("wrong length after deletes. got=%d", removed.Len())
+	}
+	if _, ok := removed.Get(&Integer{Value: 10}); ok {
+		t.Errorf("deleted key is still there")
+	}
+	if v, ok := removed.Get(&Integer{Value: 1999}); !ok || v.Inspect() != "3998" {
+		t.Errorf("wrong value for kept key. got=%v", v)
+	}
+	pairs := removed.OrderedPairs()
+	if pairs[0].Key.Inspect() != "1500" || pairs[len(pairs)-1].Key.Inspect() != "new" {
+		t.Errorf("deletes did not keep the order. first=%s, last=%s", pairs[0].Key.Inspect(), pairs[len(pairs)-1].Key.Inspect())
+	}
+}
+
+func TestArrayPushAndRest(t *testing.T) {
+	a := &Array{}
+	for i := 0; i < 100; i++ {
+		a = a.Push(&Integer{Value: int64(i)})
+	}
+	b := a.Push(&String{Value: "b"})
+	c := a.Push(&String{Value: "c"})
+	rest := b.Rest().Rest()
+
+	tests := []struct {
+		array    *Array
+		length   int
+		last     string
+		expected string
+	}{
+		{a, 100, "99", "0"},
+		{b, 101, "b", "0"},
+		{c, 101, "c", "0"},
+		{rest, 99, "b", "2"},
+	}
+	for _, tt := range tests {
+		if len(tt.array.Elements) != tt.length {
+			t.Errorf("wrong length. want=%d, got=%d", tt.length, len(tt.array.Elements))
+			continue
+		}
+		if last := tt.array.Elements[tt.length-1].Inspect(); last != tt.last {
+			t.Errorf("wrong last element. want=%s, got=%s", tt.last, last)
+		}
+		if first := tt.array.Elements[0].Inspect(); first != tt.expected {
+			t.Errorf("wrong first element. want=%s, got=%s", tt.expected, first)
+		}
+	}
+}
+
+func TestArrayMutationKeepsDerivedArrays(t *testing.T) {
+	a := &Array{}
+	for i := 0; i < 4; i++ {
+		a = a.Push(&Integer{Value: int64(i)})
+	}
+	b := a.Push(&Integer{Value: 4})
+	rest := a.Rest()
+
+	a.Append(&Integer{Value: 9})
+	b.Pop()
+	b.Append(&Integer{Value: 5})
+	rest.Insert(0, &Integer{Value: 7})
+	a.Remove(0)
+	a.Reverse()
+
+	tests := []struct {
+		array    *Array
+		expected string
+	}{
+		{a, "[9, 3, 2, 1]"},
+		{b, "[0, 1, 2, 3, 5]"},
+		{rest, "[7, 1, 2, 3]"},
+	}
+	for _, tt := range tests {
+		if got := tt.array.Inspect(); got != tt.expected {
+			t.Errorf("wrong elements. want=%s, got=%s", tt.expected, got)
+		}
+	}
+}
+
+// copyPush and copyHashWith are push and hash updates as they were before
+// arrays and hashes shared structure, for comparison in the benchmarks.
+func copyPush(a *Array, el Object) *Array {
+	elements := make([]Object, len(a.Elements)+1)
+	copy(elements, a.Elements)
+	elements[len(a.Elements)] = el
+	return &Array{Elements: elements}
+}
+
+func copyHashWith(h *Hash, key, value Object) *Hash {
+	result := NewHash()
+	for _, pair := range h.OrderedPairs() {
+		result.Put(pair.Key, pair.Value)
+	}
+	result.Put(key, value)
+	return result
+}
+
+func BenchmarkPush(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("copy/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a := &Array{}
+				for j := 0; j < n; j++ {
+					a = copyPush(a, NULL)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("shared/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a := &Array{}
+				for j := 0; j < n; j++ {
+					a = a.Push(NULL)
+				}
+			}
+		})
+	}
+}
+
+// restResult keeps the compiler from optimising the arrays away.
+var restResult *Array
+
+func BenchmarkRest(b *testing.B) {
+	a := &Array{Elements: make([]Object, 10000)}
+	// an array made by Rest shares its elements from then on, while a has
+	// them to itself and would copy them
+	shared := a.Rest()
+	b.Run("copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			elements := make([]Object, len(a.Elements)-1)
+			copy(elements, a.Elements[1:])
+			restResult = &Array{Elements: elements}
+		}
+	})
+	b.Run("shared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			restResult = shared.Rest()
+		}
+	})
+}
+
+func BenchmarkHashWith(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		h := NewHash()
+		for j := 0; j < n; j++ {
+			h.Put(&Integer{Value: int64(j)}, NULL)
+		}
+		key := &Integer{Value: int64(n / 2)}
+		b.Run(fmt.Sprintf("copy/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copyHashWith(h, key, TRUE)
+			}
+		})
+		b.Run(fmt.Sprintf("shared/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.With(key, TRUE)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const RANGE_OBJ = "RANGE"
+
+// Range is the lazy result of `start..end`. It covers start up to but not
+// including end and never materialises its elements.
+type Range struct {
+	Start int64
+	End   int64
+}
+
+func (r *Range) Type() ObjectType {
+	return RANGE_OBJ
+}
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+
+// Len is the number of integers in the range, zero when end <= start.
+func (r *Range) Len() int64 {
+	if r.End <= r.Start {
+		return 0
+	}
+	return r.End - r.Start
+}
+
+func (r *Range) Iterator() Iterator {
+	return &RangeIterator{next: r.Start, end: r.End}
+}
+
+type RangeIterator struct {
+	next int64
+	end  int64
+}
+
+func (ri *RangeIterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+func (ri *RangeIterator) Inspect() string {
+	return "iterator"
+}
+func (ri *RangeIterator) Next() (Object, bool) {
+	if ri.next >= ri.end {
+		return nil, false
+	}
+	value := &Integer{Value: ri.next}
+	ri.next++
+	return value, true
+}
+
+// Slice returns left[start:end] for arrays and strings, where strings are
+// sliced by characters. A nil or NULL bound was left out. Negative bounds
+// count from the end and bounds past either end are clamped, so slicing
+// never fails on the numbers alone.
+func Slice(left, start, end Object) (Object, error) {
+	var length int64
+	switch left := left.(type) {
+	case *Array:
+		length = int64(len(left.Elements))
+	case *String:
+		length = int64(utf8.RuneCountInString(left.Value))
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", left.Type())
+	}
+
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		to = from
+	}
+
+	switch left := left.(type) {
+	case *Array:
+		elements := make([]Object, to-from)
+		copy(elements, left.Elements[from:to])
+		return &Array{Elements: elements}, nil
+	default:
+		runes := []rune(left.(*String).Value)
+		return &String{Value: string(runes[from:to])}, nil
+	}
+}
+
+func sliceBound(bound Object, omitted, length int64) (int64, error) {
+	switch bound := bound.(type) {
+	case nil, *Null:
+		return omitted, nil
+	case *Integer:
+		i := bound.Value
+		if i < 0 {
+			i += length
+		}
+		return min(max(i, 0), length), nil
+	}
+	return 0, fmt.Errorf("slice bounds must be INTEGER, got %s", bound.Type())
+}
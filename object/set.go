@@ -0,0 +1,116 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+)
+
+const SET_OBJ = "SET"
+
+// Set holds distinct hashable values. Elements are kept in the order they
+// were first added so that Inspect and iteration are predictable.
+type Set struct {
+	elements []Object
+	buckets  buckets
+}
+
+func NewSet() *Set {
+	return &Set{buckets: make(buckets)}
+}
+
+func (s *Set) Type() ObjectType {
+	return SET_OBJ
+}
+func (s *Set) Inspect() string {
+	var out bytes.Buffer
+
+	var elements []string
+	for _, el := range s.Values() {
+		elements = append(elements, el.Inspect())
+	}
+
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Add inserts el unless an equal value is already present. It reports false
+// when el cannot be hashed.
+func (s *Set) Add(el Object) bool {
+	key, i, ok := s.buckets.find(el, s.at)
+	if !ok {
+		return false
+	}
+	if i < 0 {
+		s.buckets[key] = append(s.buckets[key], len(s.elements))
+		s.elements = append(s.elements, snapshot(el))
+	}
+	return true
+}
+
+// Has reports whether a value equal to el is in the set.
+func (s *Set) Has(el Object) bool {
+	_, i, _ := s.buckets.find(el, s.at)
+	return i >= 0
+}
+
+func (s *Set) Len() int {
+	return len(s.elements)
+}
+
+func (s *Set) at(i int) Object {
+	return s.elements[i]
+}
+
+// Values returns the elements in insertion order. The slice belongs to the
+// set and must not be modified.
+func (s *Set) Values() []Object {
+	return s.elements
+}
+
+func (s *Set) Union(other *Set) *Set {
+	result := NewSet()
+	for _, el := range s.Values() {
+		result.Add(el)
+	}
+	for _, el := range other.Values() {
+		result.Add(el)
+	}
+	return result
+}
+
+func (s *Set) Intersection(other *Set) *Set {
+	result := NewSet()
+	for _, el := range s.elements {
+		if other.Has(el) {
+			result.Add(el)
+		}
+	}
+	return result
+}
+
+func (s *Set) Difference(other *Set) *Set {
+	result := NewSet()
+	for _, el := range s.elements {
+		if !other.Has(el) {
+			result.Add(el)
+		}
+	}
+	return result
+}
+
+// IsSubset reports whether every element of s is also in other.
+func (s *Set) IsSubset(other *Set) bool {
+	for _, el := range s.elements {
+		if !other.Has(el) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Set) Iterator() Iterator {
+	return &ArrayIterator{array: &Array{Elements: s.Values()}}
+}
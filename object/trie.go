@@ -0,0 +1,258 @@
+package object
+
+import "math/bits"
+
+// The persistent structures behind Hash. Updating one copies only the nodes
+// on the path to the change and shares the rest, so the old version stays
+// valid and an update costs O(log32 n) rather than a copy of everything.
+
+const (
+	trieBits  = 5
+	trieWidth = 1 << trieBits
+	trieMask  = trieWidth - 1
+)
+
+// pairVector is a persistent vector of pairs: a trie of trieWidth-wide nodes
+// holding all but the last few pairs, which are kept in tail so that most
+// pushes copy nothing but the tail. The zero value is an empty vector.
+type pairVector struct {
+	count int
+	shift uint
+	root  *vectorNode
+	tail  []HashPair
+}
+
+type vectorNode struct {
+	children []*vectorNode // set in inner nodes
+	pairs    []HashPair    // set in leaves
+}
+
+func (v pairVector) tailOffset() int {
+	if v.count < trieWidth {
+		return 0
+	}
+	return ((v.count - 1) >> trieBits) << trieBits
+}
+
+func (v pairVector) get(i int) HashPair {
+	if off := v.tailOffset(); i >= off {
+		return v.tail[i-off]
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= trieBits {
+		node = node.children[(i>>level)&trieMask]
+	}
+	return node.pairs[i&trieMask]
+}
+
+func (v pairVector) push(pair HashPair) pairVector {
+	if v.count-v.tailOffset() < trieWidth {
+		tail := make([]HashPair, len(v.tail), len(v.tail)+1)
+		copy(tail, v.tail)
+		v.tail = append(tail, pair)
+		v.count++
+		return v
+	}
+
+	// the tail is full: move it into the trie, adding a level on top when
+	// the trie is full too
+	leaf := &vectorNode{pairs: v.tail}
+	switch {
+	case v.root == nil:
+		v.root = &vectorNode{children: []*vectorNode{leaf}}
+		v.shift = trieBits
+	case v.count>>trieBits > 1<<v.shift:
+		v.root = &vectorNode{children: []*vectorNode{v.root, newPath(v.shift, leaf)}}
+		v.shift += trieBits
+	default:
+		v.root = pushLeaf(v.count, v.shift, v.root, leaf)
+	}
+	v.tail = []HashPair{pair}
+	v.count++
+	return v
+}
+
+// pushLeaf returns a copy of parent with leaf added after its last leaf.
+func pushLeaf(count int, level uint, parent, leaf *vectorNode) *vectorNode {
+	i := ((count - 1) >> level) & trieMask
+	children := make([]*vectorNode, len(parent.children), max(len(parent.children), i+1))
+	copy(children, parent.children)
+
+	var child *vectorNode
+	switch {
+	case level == trieBits:
+		child = leaf
+	case i < len(parent.children):
+		child = pushLeaf(count, level-trieBits, parent.children[i], leaf)
+	default:
+		child = newPath(level-trieBits, leaf)
+	}
+	if i < len(children) {
+		children[i] = child
+	} else {
+		children = append(children, child)
+	}
+	return &vectorNode{children: children}
+}
+
+// newPath wraps leaf in single-child nodes up to level.
+func newPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode{children: []*vectorNode{newPath(level-trieBits, leaf)}}
+}
+
+func (v pairVector) set(i int, pair HashPair) pairVector {
+	if off := v.tailOffset(); i >= off {
+		tail := make([]HashPair, len(v.tail))
+		copy(tail, v.tail)
+		tail[i-off] = pair
+		v.tail = tail
+		return v
+	}
+	v.root = setPair(v.root, v.shift, i, pair)
+	return v
+}
+
+func setPair(node *vectorNode, level uint, i int, pair HashPair) *vectorNode {
+	if level == 0 {
+		pairs := make([]HashPair, len(node.pairs))
+		copy(pairs, node.pairs)
+		pairs[i&trieMask] = pair
+		return &vectorNode{pairs: pairs}
+	}
+	children := make([]*vectorNode, len(node.children))
+	copy(children, node.children)
+	sub := (i >> level) & trieMask
+	children[sub] = setPair(children[sub], level-trieBits, i, pair)
+	return &vectorNode{children: children}
+}
+
+// hamt is a persistent hash array mapped trie from HashKey to the positions
+// of the values with that key. Each level uses trieBits bits of the key to
+// pick a slot, and a bitmap records which slots are in use so that a node
+// stores only those. A nil *hamt is empty.
+type hamt struct {
+	bitmap uint32
+	slots  []hamtSlot
+}
+
+// hamtSlot holds either a deeper node or the buckets of keys whose Values
+// are all the same, which only differ in their type.
+type hamtSlot struct {
+	node    *hamt
+	buckets []hamtBucket
+}
+
+type hamtBucket struct {
+	key       HashKey
+	positions []int
+}
+
+func (n *hamt) get(key HashKey) []int {
+	for shift := uint(0); n != nil; shift += trieBits {
+		bit := uint32(1) << ((key.Value >> shift) & trieMask)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		slot := n.slots[bits.OnesCount32(n.bitmap&(bit-1))]
+		if slot.node == nil {
+			for _, b := range slot.buckets {
+				if b.key == key {
+					return b.positions
+				}
+			}
+			return nil
+		}
+		n = slot.node
+	}
+	return nil
+}
+
+// put returns a trie in which key maps to positions, or to nothing when
+// positions is empty.
+func (n *hamt) put(key HashKey, positions []int) *hamt {
+	return n.putAt(key, positions, 0)
+}
+
+func (n *hamt) putAt(key HashKey, positions []int, shift uint) *hamt {
+	if n == nil {
+		if len(positions) == 0 {
+			return nil
+		}
+		n = &hamt{}
+	}
+	bit := uint32(1) << ((key.Value >> shift) & trieMask)
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		if len(positions) == 0 {
+			return n
+		}
+		return n.withSlot(bit, i, hamtSlot{buckets: []hamtBucket{{key: key, positions: positions}}}, true)
+	}
+
+	slot := n.slots[i]
+	switch {
+	case slot.node != nil:
+		slot.node = slot.node.putAt(key, positions, shift+trieBits)
+		if slot.node == nil {
+			return n.withoutSlot(bit, i)
+		}
+	case slot.buckets[0].key.Value == key.Value:
+		slot.buckets = putBucket(slot.buckets, key, positions)
+		if len(slot.buckets) == 0 {
+			return n.withoutSlot(bit, i)
+		}
+	default:
+		if len(positions) == 0 {
+			return n
+		}
+		// the Values differ, so a level further down tells them apart
+		var node *hamt
+		for _, b := range slot.buckets {
+			node = node.putAt(b.key, b.positions, shift+trieBits)
+		}
+		slot = hamtSlot{node: node.putAt(key, positions, shift+trieBits)}
+	}
+	return n.withSlot(bit, i, slot, false)
+}
+
+// withSlot returns a copy of n with slot at i, inserted there when insert is
+// set and replacing the slot at i otherwise.
+func (n *hamt) withSlot(bit uint32, i int, slot hamtSlot, insert bool) *hamt {
+	if !insert {
+		slots := make([]hamtSlot, len(n.slots))
+		copy(slots, n.slots)
+		slots[i] = slot
+		return &hamt{bitmap: n.bitmap, slots: slots}
+	}
+	slots := make([]hamtSlot, 0, len(n.slots)+1)
+	slots = append(slots, n.slots[:i]...)
+	slots = append(slots, slot)
+	slots = append(slots, n.slots[i:]...)
+	return &hamt{bitmap: n.bitmap | bit, slots: slots}
+}
+
+func (n *hamt) withoutSlot(bit uint32, i int) *hamt {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]hamtSlot, 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:i]...)
+	slots = append(slots, n.slots[i+1:]...)
+	return &hamt{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+func putBucket(buckets []hamtBucket, key HashKey, positions []int) []hamtBucket {
+	result := make([]hamtBucket, 0, len(buckets)+1)
+	for _, b := range buckets {
+		if b.key != key {
+			result = append(result, b)
+		}
+	}
+	if len(positions) > 0 {
+		result = append(result, hamtBucket{key: key, positions: positions})
+	}
+	return result
+}
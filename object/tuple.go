@@ -0,0 +1,75 @@
+package object
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+const TUPLE_OBJ = "TUPLE"
+
+// Tuple is a fixed sequence of values. Unlike an array it cannot change, so
+// it is compared and hashed by its elements.
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() ObjectType {
+	return TUPLE_OBJ
+}
+func (t *Tuple) Inspect() string {
+	var elements []string
+	for _, el := range t.Elements {
+		elements = append(elements, el.Inspect())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// HashKey combines the keys of the elements.
+func (t *Tuple) HashKey() HashKey {
+	h := fnv.New64a()
+	writeHashKeys(h, t.Elements)
+	return HashKey{Type: t.Type(), Value: h.Sum64()}
+}
+
+// Equal reports whether both tuples hold the same elements.
+func (t *Tuple) Equal(other *Tuple) bool {
+	return equalElements(t.Elements, other.Elements)
+}
+
+// Index returns an element of the tuple, counting from the end for negative
+// indexes.
+func (t *Tuple) Index(index Object) (Object, error) {
+	i, ok := index.(*Integer)
+	if !ok {
+		return nil, fmt.Errorf("unusable as tuple index: %s", index.Type())
+	}
+	n := i.Value
+	if n < 0 {
+		n += int64(len(t.Elements))
+	}
+	if n < 0 || n >= int64(len(t.Elements)) {
+		return NULL, nil
+	}
+	return t.Elements[n], nil
+}
+
+// Unpack returns the elements of a tuple or array destructured into n names.
+func Unpack(value Object, n int) ([]Object, error) {
+	var elements []Object
+	switch value := value.(type) {
+	case *Tuple:
+		elements = value.Elements
+	case *Array:
+		elements = value.Elements
+	default:
+		return nil, fmt.Errorf("cannot unpack %s into %d values", value.Inspect(), n)
+	}
+	if len(elements) != n {
+		return nil, fmt.Errorf("cannot unpack %s into %d values", value.Inspect(), n)
+	}
+	return elements, nil
+}
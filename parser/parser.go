@@ -11,8 +11,10 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	PIPE         // |>
 	EQUALS       // =
 	LESS_GREATER // < or >
+	RANGE        // ..
 	SUM          // +
 	PRODUCT      // *
 	PREFIX       // -X or!X
@@ -21,10 +23,13 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.PIPE:     PIPE,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESS_GREATER,
 	token.GT:       LESS_GREATER,
+	token.IN:       LESS_GREATER,
+	token.DOTDOT:   RANGE,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
@@ -46,6 +51,8 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	inGenerator bool // true while parsing the body of a `fn*`
 }
 
 func (p *Parser) registerPrefixFn(tokenType token.TokenType, fn prefixParseFn) {
@@ -79,6 +86,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefixFn(token.STRING, p.parseStringLiteral)
 	p.registerPrefixFn(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefixFn(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefixFn(token.LSET, p.parseSetLiteral)
+	p.registerPrefixFn(token.YIELD, p.parseYieldExpression)
+	p.registerPrefixFn(token.SPAWN, p.parseSpawnExpression)
+	p.registerPrefixFn(token.SELECT, p.parseSelectExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfixFn(token.PLUS, p.parseInfixExpression)
@@ -89,6 +100,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfixFn(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfixFn(token.LT, p.parseInfixExpression)
 	p.registerInfixFn(token.GT, p.parseInfixExpression)
+	p.registerInfixFn(token.DOTDOT, p.parseInfixExpression)
+	p.registerInfixFn(token.IN, p.parseInfixExpression)
+	p.registerInfixFn(token.PIPE, p.parsePipeExpression)
 	p.registerInfixFn(token.LPAREN, p.parseCallExpression)
 	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
 
@@ -127,36 +141,140 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.ENUM:
+		return p.parseEnumStatement()
+	case token.IMPL:
+		return p.parseImplStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
-
+func (p *Parser) parseEnumStatement() ast.Statement {
+	stmt := &ast.EnumStatement{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		variant := &ast.EnumVariant{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if p.peekTokenIs(token.RPAREN) {
+				p.appendError(fmt.Sprintf("variant %s needs at least one field", variant.Name.Value))
+				return nil
+			}
+			variant.Fields, _ = p.parseFunctionParameters()
+			if variant.Fields == nil {
+				return nil
+			}
+		}
+		stmt.Variants = append(stmt.Variants, variant)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	if len(stmt.Variants) == 0 {
+		p.appendError(fmt.Sprintf("enum %s has no variants", stmt.Name.Value))
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
 
+func (p *Parser) parseImplStatement() ast.Statement {
+	stmt := &ast.ImplStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Methods = p.parseHashLiteral()
+	if stmt.Methods == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
 
+func (p *Parser) parseLetStatement() *ast.LetStatement {
+	stmt := &ast.LetStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		stmt.Names = p.parseDestructuredNames()
+		if stmt.Names == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if stmt.Name != nil && p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseTypeAnnotation()
+		if stmt.Type == nil {
+			return nil
+		}
+	}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
 }
 
+// parseDestructuredNames parses the `(a, b)` of `let (a, b) = ...`.
+func (p *Parser) parseDestructuredNames() []*ast.Identifier {
+	var names []*ast.Identifier
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		names = append(names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return names
+}
+
 func (p *Parser) curTokenIs(t token.TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -178,6 +296,16 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 	p.nextToken()
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.COMMA) {
+		// `return a, b` returns the tuple (a, b)
+		tuple := &ast.TupleLiteral{Token: stmt.Token, Elements: []ast.Expression{stmt.ReturnValue}}
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			p.nextToken()
+			tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+		}
+		stmt.ReturnValue = tuple
+	}
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -260,17 +388,48 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	expression := &ast.PipeExpression{Token: p.curToken, Left: left}
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+// parseGroupedExpression parses a parenthesized expression, or a tuple when
+// the parentheses hold a comma: `()`, `(a,)`, `(a, b)`.
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	tok := p.curToken
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return &ast.TupleLiteral{Token: tok}
+	}
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.COMMA) {
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return exp
+	}
+
+	tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{exp}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
-	return exp
+	return tuple
 }
 
 func (p *Parser) parseIfExpression() ast.Expression {
@@ -316,38 +475,267 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	fl := &ast.FunctionLiteral{Token: p.curToken}
+	if p.peekTokenIs(token.ASTRISK) {
+		p.nextToken()
+		fl.IsGenerator = true
+	}
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	fl.Parameters = p.parseFunctionParameters()
+	fl.Parameters, fl.ParameterTypes = p.parseFunctionParameters()
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		fl.ReturnType = p.parseTypeAnnotation()
+		if fl.ReturnType == nil {
+			return nil
+		}
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+	enclosingGenerator := p.inGenerator
+	p.inGenerator = fl.IsGenerator
 	fl.Body = p.parseBlockStatement()
+	p.inGenerator = enclosingGenerator
 	return fl
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	var identifiers []*ast.Identifier
-	if p.peekTokenIs(token.RPAREN) {
-		p.nextToken()
-		return identifiers
+func (p *Parser) parseYieldExpression() ast.Expression {
+	exp := &ast.YieldExpression{Token: p.curToken}
+	if !p.inGenerator {
+		p.appendError("yield outside of generator function")
+		return nil
 	}
 	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+	return exp
+}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	exp := &ast.SpawnExpression{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	args := p.parseExpressionList(token.RPAREN)
+	if len(args) == 0 {
+		p.appendError("spawn expects a function to run")
+		return nil
+	}
+	exp.Function = args[0]
+	exp.Arguments = args[1:]
+	return exp
+}
 
-	for p.peekTokenIs(token.COMMA) {
+func (p *Parser) parseSelectExpression() ast.Expression {
+	exp := &ast.SelectExpression{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) {
+		switch p.curToken.Type {
+		case token.CASE:
+			sc := p.parseSelectCase()
+			if sc == nil {
+				return nil
+			}
+			exp.Cases = append(exp.Cases, sc)
+		case token.DEFAULT:
+			if exp.Default != nil {
+				p.appendError("select has more than one default")
+				return nil
+			}
+			if !p.expectPeek(token.LBRACE) {
+				return nil
+			}
+			exp.Default = p.parseBlockStatement()
+		default:
+			msg := fmt.Sprintf("expected case or default in select, got %s instead", p.curToken.Type)
+			p.appendError(msg)
+			return nil
+		}
+		p.nextToken()
+	}
+	if len(exp.Cases) == 0 && exp.Default == nil {
+		p.appendError("select needs at least one case")
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseSelectCase() *ast.SelectCase {
+	sc := &ast.SelectCase{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	if p.peekTokenIs(token.ASSIGN) {
+		sc.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+	}
+
+	operation := p.curToken.Literal
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	args := p.parseExpressionList(token.RPAREN)
+	switch {
+	case operation == "recv" && len(args) == 1:
+		sc.Channel = args[0]
+	case operation == "send" && len(args) == 2 && sc.Variable == nil:
+		sc.Send = true
+		sc.Channel = args[0]
+		sc.Value = args[1]
+	default:
+		p.appendError("select case must be `recv(channel)`, `name = recv(channel)` or `send(channel, value)`")
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	sc.Body = p.parseBlockStatement()
+	return sc
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []*ast.TypeAnnotation) {
+	var identifiers []*ast.Identifier
+	var types []*ast.TypeAnnotation
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return identifiers, types
+	}
+
+	for {
 		p.nextToken()
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
+
+		var typ *ast.TypeAnnotation
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			typ = p.parseTypeAnnotation()
+			if typ == nil {
+				return nil, nil
+			}
+		}
+		types = append(types, typ)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+	return identifiers, types
+}
+
+// parseTypeAnnotation parses a type starting at the current token.
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	typ := &ast.TypeAnnotation{Token: p.curToken}
+	switch p.curToken.Type {
+	case token.IDENT:
+		typ.Name = p.curToken.Literal
+		return typ
+	case token.LBRACKET:
+		typ.Name = "array"
+		if !p.parseTypeParameters(typ, token.RBRACKET) {
+			return nil
+		}
+	case token.LSET:
+		typ.Name = "set"
+		if !p.parseTypeParameters(typ, token.RBRACE) {
+			return nil
+		}
+	case token.LBRACE:
+		typ.Name = "hash"
+		if !p.parseTypeParameters(typ, token.COLON) || !p.parseTypeParameters(typ, token.RBRACE) {
+			return nil
+		}
+	case token.FUNCTION:
+		typ.Name = "fn"
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+		} else {
+			for {
+				if !p.parseTypeParameters(typ, token.COMMA, token.RPAREN) {
+					return nil
+				}
+				if p.curTokenIs(token.RPAREN) {
+					break
+				}
+			}
+		}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			typ.Return = p.parseTypeAnnotation()
+			if typ.Return == nil {
+				return nil
+			}
+		}
+	default:
+		p.appendError(fmt.Sprintf("expected a type, got %s instead", p.curToken.Type))
 		return nil
 	}
-	return identifiers
+	return typ
+}
+
+// parseTypeParameters parses the type after the current token into typ and
+// then expects one of the given closing tokens.
+func (p *Parser) parseTypeParameters(typ *ast.TypeAnnotation, end ...token.TokenType) bool {
+	p.nextToken()
+	param := p.parseTypeAnnotation()
+	if param == nil {
+		return false
+	}
+	typ.Parameters = append(typ.Parameters, param)
+
+	for _, t := range end {
+		if p.peekTokenIs(t) {
+			p.nextToken()
+			return true
+		}
+	}
+	p.peekError(end[0])
+	return false
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -357,15 +745,69 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Value: p.curToken.Literal}
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
-	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return array
+	}
+	p.nextToken()
+	first := p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.FOR) {
+		p.nextToken()
+		comprehension := &ast.ArrayComprehension{Token: array.Token, Element: first}
+		comprehension.Clause = p.parseComprehensionClause()
+		if comprehension.Clause == nil || !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return comprehension
+	}
+	array.Elements = p.parseRestOfExpressionList(first, token.RBRACKET)
 	return array
 }
 
+// parseComprehensionClause parses `for x in xs if cond` or `for k, v in h`,
+// starting at the 'for'.
+func (p *Parser) parseComprehensionClause() *ast.ComprehensionClause {
+	clause := &ast.ComprehensionClause{Token: p.curToken}
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		clause.Variables = append(clause.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if len(clause.Variables) > 2 {
+		p.appendError("a comprehension takes one or two variables")
+		return nil
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	clause.Iterable = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		clause.Condition = p.parseExpression(LOWEST)
+	}
+	return clause
+}
+
+func (p *Parser) parseSetLiteral() ast.Expression {
+	set := &ast.SetLiteral{Token: p.curToken}
+	set.Elements = p.parseExpressionList(token.RBRACE)
+	return set
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	var list []ast.Expression
 	if p.peekTokenIs(end) {
@@ -373,7 +815,13 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		return list
 	}
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	return p.parseRestOfExpressionList(p.parseExpression(LOWEST), end)
+}
+
+// parseRestOfExpressionList continues a list whose first element has
+// already been parsed.
+func (p *Parser) parseRestOfExpressionList(first ast.Expression, end token.TokenType) []ast.Expression {
+	list := []ast.Expression{first}
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
@@ -387,8 +835,29 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		exp.Index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(exp)
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return exp
+}
+
+// parseSliceExpression continues an index expression at its ':'. Either
+// bound may be left out.
+func (p *Parser) parseSliceExpression(index *ast.IndexExpression) ast.Expression {
+	exp := &ast.SliceExpression{Token: index.Token, Left: index.Left, Start: index.Index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -407,7 +876,18 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
+
+		if len(hash.Pairs) == 0 && p.peekTokenIs(token.FOR) {
+			p.nextToken()
+			comprehension := &ast.HashComprehension{Token: hash.Token, Key: key, Value: value}
+			comprehension.Clause = p.parseComprehensionClause()
+			if comprehension.Clause == nil || !p.expectPeek(token.RBRACE) {
+				return nil
+			}
+			return comprehension
+		}
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !(p.peekTokenIs(token.RBRACE) || p.expectPeek(token.COMMA)) {
 			return nil
 		}
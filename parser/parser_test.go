@@ -16,6 +16,7 @@ func TestLetStatement(t *testing.T) {
 		{"let x = 5;", "x", 5},
 		{"let y = true;", "y", true},
 		{"let foobar = y;", "foobar", "y"},
+		{"const z = 10;", "z", 10},
 	}
 
 	for _, tt := range tests {
@@ -263,6 +264,9 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"false", "false", 1},
 		{"3 > 5 == false", "((3 > 5) == false)", 1},
 		{"3 < 5 == true", "((3 < 5) == true)", 1},
+		{"a in b == true", "((a in b) == true)", 1},
+		{"a + 1 in 0..n + 1", "((a + 1) in (0 .. (n + 1)))", 1},
+		{"!a in b", "((!a) in b)", 1},
 		{"1 + (2 + 3) + 4", "((1 + (2 + 3)) + 4)", 1},
 		{"(5 + 5) * 2", "((5 + 5) * 2)", 1},
 		{"2 / (5 + 5)", "(2 / (5 + 5))", 1},
@@ -441,6 +445,220 @@ func TestArrayLiteral(t *testing.T) {
 	testInfixExpression(t, a.Elements[2], 4, "+", 5)
 }
 
+func TestSliceAndRangeParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:-1]", "(a[:(-1)])"},
+		{"a[2:]", "(a[2:])"},
+		{"a[:]", "(a[:])"},
+		{"a[i + 1:n * 2]", "(a[(i + 1):(n * 2)])"},
+		{"1..10", "(1 .. 10)"},
+		{"a + 1..b * 2", "((a + 1) .. (b * 2))"},
+		{"0..n < m", "((0 .. n) < m)"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestPipeExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs |> f", "(xs |> f)"},
+		{"xs |> filter(even) |> map(double)", "((xs |> filter(even) ) |> map(double) )"},
+		{"a + b |> f", "((a + b) |> f)"},
+		{"a == b |> f", "((a == b) |> f)"},
+		{"xs |> fn(x) { x }", "(xs |> fn(x) x)"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let xs: [string] = [];", "let xs: [string] = [];"},
+		{"let h: {string: [int]} = {};", "let h: {string: [int]} = {};"},
+		{"let s: #{int} = #{};", "let s: #{int} = #{};"},
+		{"fn(a: int, b: string): bool { a }", "fn(a: int, b: string): bool a"},
+		{"fn(a, b: int) { a }", "fn(a, b: int) a"},
+		{"let f: fn(int, int): int = g;", "let f: fn(int, int): int = g;"},
+		{"fn(f: fn(): fn(int)): int { 1 }", "fn(f: fn(): fn(int)): int 1"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := parseAndTestCommonStep(t, "fn(a: int, b) { a }", 1)
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(fn.ParameterTypes) != 2 || fn.ParameterTypes[0].Name != "int" || fn.ParameterTypes[1] != nil {
+		t.Errorf("wrong parameter types. got=%v", fn.ParameterTypes)
+	}
+	if fn.ReturnType != nil {
+		t.Errorf("fn.ReturnType is not nil. got=%s", fn.ReturnType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: = 5;", "expected a type, got = instead"},
+		{"let x: [int = 5;", "expected next token to be ], got = instead"},
+		{"fn(a: {int}) { a }", "expected next token to be :, got } instead"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+		if p.Errors()[0] != tt.expected {
+			t.Errorf("wrong parser error. want=%q, got=%q", tt.expected, p.Errors()[0])
+		}
+	}
+}
+
+func TestComprehensionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[x * 2 for x in xs]", "[(x * 2) for x in xs]"},
+		{"[x for x in xs if x > 0]", "[x for x in xs if (x > 0)]"},
+		{"[k for k, v in h]", "[k for k, v in h]"},
+		{"{k: v + 1 for k, v in h if v}", "{k:(v + 1) for k, v in h if v}"},
+		{"[[y for y in x] for x in xs]", "[[y for y in x] for x in xs]"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"[x for a, b, c in xs]", "a comprehension takes one or two variables"},
+		{"[x for 1 in xs]", "expected next token to be IDENT, got INT instead"},
+		{"{k: v for k in xs, 1: 2}", "expected next token to be }, got , instead"},
+	}
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want first=%q, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestTupleLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"()", "()"},
+		{"(1,)", "(1,)"},
+		{"(1, 2 * 3, a)", "(1, (2 * 3), a)"},
+		{"(1, (2, 3),)", "(1, (2, 3))"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		stmt := parseAndTestExpressionStatement(t, program)
+		tuple, ok := stmt.Expression.(*ast.TupleLiteral)
+		if !ok {
+			t.Fatalf("statement expression is not a tuple literal. Got %T", stmt.Expression)
+		}
+		if tuple.String() != tt.expected {
+			t.Errorf("wrong tuple literal. want=%s, got=%s", tt.expected, tuple.String())
+		}
+	}
+
+	program := parseAndTestCommonStep(t, "(1 + 2)", 1)
+	stmt := parseAndTestExpressionStatement(t, program)
+	testInfixExpression(t, stmt.Expression, 1, "+", 2)
+}
+
+func TestDestructuringAndMultipleReturns(t *testing.T) {
+	program := parseAndTestCommonStep(t, "let (q, r) = (7, 2); fn() { return a, b + 1; }", 2)
+	let, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("statement is not a let statement. Got %T", program.Statements[0])
+	}
+	if let.Name != nil || len(let.Names) != 2 {
+		t.Fatalf("wrong names. Got %v and %v", let.Name, let.Names)
+	}
+	testIdentifier(t, let.Names[1], "r")
+	if let.String() != "let (q, r) = (7, 2);" {
+		t.Errorf("wrong let statement. Got %q", let.String())
+	}
+
+	fn := parseAndTestExpressionStatement(t, &ast.Program{Statements: program.Statements[1:]}).Expression.(*ast.FunctionLiteral)
+	ret := fn.Body.Statements[0].(*ast.ReturnStatement)
+	if ret.ReturnValue.String() != "(a, (b + 1))" {
+		t.Errorf("wrong return value. Got %q", ret.ReturnValue.String())
+	}
+
+	errors := map[string]string{
+		`let () = x`:       "expected next token to be IDENT, got ) instead",
+		`let (a, 1) = x`:   "expected next token to be IDENT, got INT instead",
+		`let (a: int) = x`: "expected next token to be ), got : instead",
+	}
+	for input, expected := range errors {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for %q", input)
+		}
+		if p.Errors()[0] != expected {
+			t.Errorf("wrong parser error for %q. want=%q, got=%q", input, expected, p.Errors()[0])
+		}
+	}
+}
+
+func TestSetLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#{}", "#{}"},
+		{"#{1, 2 * 3, a}", "#{1, (2 * 3), a}"},
+		{"#{#{1}}", "#{#{1}}"},
+	}
+	for _, tt := range tests {
+		program := parseAndTestCommonStep(t, tt.input, 1)
+		stmt := parseAndTestExpressionStatement(t, program)
+		set, ok := stmt.Expression.(*ast.SetLiteral)
+		if !ok {
+			t.Fatalf("statement expression is not a set literal. Got %T", stmt.Expression)
+		}
+		if set.String() != tt.expected {
+			t.Errorf("wrong set literal. want=%s, got=%s", tt.expected, set.String())
+		}
+	}
+}
+
 func TestParsingIndexExpression(t *testing.T) {
 	input := "myArray[1 + 2]"
 	program := parseAndTestCommonStep(t, input, 1)
@@ -481,6 +699,9 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		expectedValue := expected[literal.Value] // TODO check why `String()` is not working here
 		testIntegerLiteral(t, value, expectedValue)
 	}
+	if hl.String() != `{one:1, two:2, three:3}` {
+		t.Errorf("keys are not in source order. got=%s", hl.String())
+	}
 }
 
 func TestParsingHashLiteralsWithExpressions(t *testing.T) {
@@ -531,6 +752,186 @@ func TestParsingEmptyHashLiterals(t *testing.T) {
 	}
 }
 
+func TestGeneratorLiteralParsing(t *testing.T) {
+	input := `let gen = fn*(x) { yield x; }`
+	program := parseAndTestCommonStep(t, input, 1)
+	function, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("let value is not a function literal. Got %T", program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if !function.IsGenerator {
+		t.Errorf("function literal is not a generator")
+	}
+	if function.Name != "gen" {
+		t.Errorf("function literal name is not %q. Got %q", "gen", function.Name)
+	}
+	bodyStmt := function.Body.Statements[0].(*ast.ExpressionStatement)
+	yield, ok := bodyStmt.Expression.(*ast.YieldExpression)
+	if !ok {
+		t.Fatalf("body statement is not a yield expression. Got %T", bodyStmt.Expression)
+	}
+	testIdentifier(t, yield.Value, "x")
+}
+
+func TestYieldOutsideGenerator(t *testing.T) {
+	tests := []string{
+		`yield 1`,
+		`fn() { yield 1 }`,
+		`fn*() { fn() { yield 1 } }`,
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			p := New(lexer.New(input))
+			p.ParseProgram()
+			if len(p.Errors()) == 0 {
+				t.Fatalf("expected parser errors for %q", input)
+			}
+			if p.Errors()[0] != "yield outside of generator function" {
+				t.Errorf("wrong parser error. Got %q", p.Errors()[0])
+			}
+		})
+	}
+}
+
+func TestForStatementParsing(t *testing.T) {
+	input := `for (x in xs) { x }`
+	program := parseAndTestCommonStep(t, input, 1)
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("statement is not a for statement. Got %T", program.Statements[0])
+	}
+	testIdentifier(t, stmt.Variable, "x")
+	testIdentifier(t, stmt.Iterable, "xs")
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("for body does not have 1 statement. Got %d", len(stmt.Body.Statements))
+	}
+}
+
+func TestEnumStatementParsing(t *testing.T) {
+	input := `enum Shape { Circle(r), Rect(w, h), Empty, }; Empty`
+	program := parseAndTestCommonStep(t, input, 2)
+	stmt, ok := program.Statements[0].(*ast.EnumStatement)
+	if !ok {
+		t.Fatalf("statement is not an enum statement. Got %T", program.Statements[0])
+	}
+	if stmt.String() != "enum Shape { Circle(r), Rect(w, h), Empty }" {
+		t.Errorf("wrong enum. Got %q", stmt.String())
+	}
+	if len(stmt.Variants) != 3 || len(stmt.Variants[1].Fields) != 2 {
+		t.Fatalf("wrong variants. Got %v", stmt.Variants)
+	}
+	testIdentifier(t, stmt.Variants[1].Fields[1], "h")
+
+	errors := map[string]string{
+		`enum Shape {}`:              "enum Shape has no variants",
+		`enum Shape { Circle() }`:    "variant Circle needs at least one field",
+		`enum Shape { Circle Rect }`: "expected next token to be ,, got IDENT instead",
+		`enum { Circle }`:            "expected next token to be IDENT, got { instead",
+	}
+	for input, expected := range errors {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for %q", input)
+		}
+		if p.Errors()[0] != expected {
+			t.Errorf("wrong parser error for %q. want=%q, got=%q", input, expected, p.Errors()[0])
+		}
+	}
+}
+
+func TestImplStatementParsing(t *testing.T) {
+	input := `impl Point { "+": add, "len": fn(p) { 2 } }; p`
+	program := parseAndTestCommonStep(t, input, 2)
+	stmt, ok := program.Statements[0].(*ast.ImplStatement)
+	if !ok {
+		t.Fatalf("statement is not an impl statement. Got %T", program.Statements[0])
+	}
+	testIdentifier(t, stmt.Name, "Point")
+	hash, ok := stmt.Methods.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("methods are not a hash literal. Got %T", stmt.Methods)
+	}
+	if len(hash.Pairs) != 2 {
+		t.Errorf("wrong number of methods. Got %d", len(hash.Pairs))
+	}
+
+	errors := map[string]string{
+		`impl { "+": add }`: "expected next token to be IDENT, got { instead",
+		`impl Point add`:    "expected next token to be {, got IDENT instead",
+	}
+	for input, expected := range errors {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for %q", input)
+		}
+		if p.Errors()[0] != expected {
+			t.Errorf("wrong parser error for %q. want=%q, got=%q", input, expected, p.Errors()[0])
+		}
+	}
+}
+
+func TestSpawnExpressionParsing(t *testing.T) {
+	input := `spawn(worker, 1, 2 * 3)`
+	program := parseAndTestCommonStep(t, input, 1)
+	stmt := parseAndTestExpressionStatement(t, program)
+	exp, ok := stmt.Expression.(*ast.SpawnExpression)
+	if !ok {
+		t.Fatalf("statement expression is not a spawn expression. Got %T", stmt.Expression)
+	}
+	testIdentifier(t, exp.Function, "worker")
+	if len(exp.Arguments) != 2 {
+		t.Fatalf("Wrong length of arguments. Got %d", len(exp.Arguments))
+	}
+	testLiteralExpression(t, exp.Arguments[0], 1)
+	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
+}
+
+func TestSelectExpressionParsing(t *testing.T) {
+	input := `select {
+	case x = recv(a) { x }
+	case recv(b) { 1 }
+	case send(c, 2) { 3 }
+	default { 4 }
+}`
+	program := parseAndTestCommonStep(t, input, 1)
+	stmt := parseAndTestExpressionStatement(t, program)
+	exp, ok := stmt.Expression.(*ast.SelectExpression)
+	if !ok {
+		t.Fatalf("statement expression is not a select expression. Got %T", stmt.Expression)
+	}
+	if len(exp.Cases) != 3 {
+		t.Fatalf("select does not have 3 cases. Got %d", len(exp.Cases))
+	}
+	testIdentifier(t, exp.Cases[0].Variable, "x")
+	testIdentifier(t, exp.Cases[0].Channel, "a")
+	if exp.Cases[1].Variable != nil || exp.Cases[1].Send {
+		t.Errorf("case 1 is not a plain receive")
+	}
+	if !exp.Cases[2].Send {
+		t.Errorf("case 2 is not a send")
+	}
+	testIdentifier(t, exp.Cases[2].Channel, "c")
+	testLiteralExpression(t, exp.Cases[2].Value, 2)
+	if exp.Default == nil {
+		t.Errorf("select default is nil")
+	}
+
+	for _, input := range []string{
+		`select { }`,
+		`select { case foo(a) { 1 } }`,
+		`select { case x = send(a, 1) { 1 } }`,
+		`select { default { 1 } default { 2 } }`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
 func testIntegerLiteral(t *testing.T, expression ast.Expression, value int64) bool {
 	integ, ok := expression.(*ast.IntegerLiteral)
 	if !ok {
@@ -577,8 +978,8 @@ func checkParsedErrors(t *testing.T, p *Parser) {
 }
 
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
-	if s.TokenLiteral() != "let" {
-		t.Errorf("s.TokenLiteral() not 'let', got %q", s.TokenLiteral())
+	if s.TokenLiteral() != "let" && s.TokenLiteral() != "const" {
+		t.Errorf("s.TokenLiteral() not 'let' or 'const', got %q", s.TokenLiteral())
 		return false
 	}
 	letStmt, ok := s.(*ast.LetStatement)
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"monkey/compiler"
+	"monkey/eventloop"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/typecheck"
 	"monkey/vm"
 )
 
@@ -29,8 +31,18 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
+	globals := vm.NewGlobals()
+	methods := object.NewMethods()
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	checker := typecheck.New()
+	loop := eventloop.New()
+	for _, v := range loop.Builtins() {
+		symbol := symbolTable.Define(v.Name)
+		globals.Set(symbol.Index, v.Builtin)
+	}
 
 	for {
 		_, err := fmt.Fprintf(out, PROMPT)
@@ -51,6 +63,13 @@ func Start(in io.Reader, out io.Writer) {
 			printParseError(out, p.Errors())
 			continue
 		}
+		if errs := checker.Check(program); len(errs) != 0 {
+			_, _ = io.WriteString(out, "Woops! Type errors:\n")
+			for _, e := range errs {
+				_, _ = io.WriteString(out, "\t"+e.Error()+"\n")
+			}
+			continue
+		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
 		err = comp.Compile(program)
@@ -62,7 +81,7 @@ func Start(in io.Reader, out io.Writer) {
 		code := comp.Bytecode()
 		constants = code.Constants
 
-		machine := vm.NewWithGlobalStore(code, globals)
+		machine := vm.NewWithState(code, globals, methods)
 		err = machine.Run()
 		if err != nil {
 			_, _ = fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
@@ -71,6 +90,14 @@ func Start(in io.Reader, out io.Writer) {
 		lastPopped := machine.LastPoppedStack()
 		_, _ = io.WriteString(out, lastPopped.Inspect())
 		_, _ = io.WriteString(out, "\n")
+
+		err = loop.Run(func(fn object.Object) error {
+			_, err := machine.Call(fn)
+			return err
+		})
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Woops! Timer callback failed:\n %s\n", err)
+		}
 	}
 }
 
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/eventloop"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/typecheck"
+	"monkey/vm"
+	"strings"
+)
+
+const (
+	EngineVM   = "vm"
+	EngineEval = "eval"
+)
+
+// Global is a value the host binds to a name before the program runs.
+type Global struct {
+	Name  string
+	Value object.Object
+}
+
+// Run type checks a whole program, executes it with the given engine and then
+// runs its event loop until no timers are left. The timer builtins of loop and
+// any further globals are defined for the program. An error value left by the
+// program or by a timer callback fails the run on either engine.
+func Run(input string, engine string, loop *eventloop.Loop, globals ...Global) error {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+	if errs := typecheck.Check(program); len(errs) != 0 {
+		var messages []string
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return fmt.Errorf("type errors:\n\t%s", strings.Join(messages, "\n\t"))
+	}
+
+	switch engine {
+	case EngineVM:
+		return runVM(program, loop, globals)
+	case EngineEval:
+		return runEval(program, loop, globals)
+	}
+	return fmt.Errorf("unknown engine %q", engine)
+}
+
+func runVM(program *ast.Program, loop *eventloop.Loop, defined []Global) error {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	globals := vm.NewGlobals()
+	for _, v := range loop.Builtins() {
+		symbol := symbolTable.Define(v.Name)
+		globals.Set(symbol.Index, v.Builtin)
+	}
+	for _, g := range defined {
+		globals.Set(symbolTable.Define(g.Name).Index, g.Value)
+	}
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(program)
+	if err != nil {
+		return fmt.Errorf("compilation failed: %s", err)
+	}
+
+	machine := vm.NewWithGlobalStore(comp.Bytecode(), globals)
+	err = machine.Run()
+	if err != nil {
+		return fmt.Errorf("executing bytecode failed: %s", err)
+	}
+	// the VM passes errors from builtins on as values
+	if result, ok := machine.LastPoppedStack().(*object.Error); ok {
+		return errors.New(result.Message)
+	}
+
+	return loop.Run(func(fn object.Object) error {
+		result, err := machine.Call(fn)
+		if err != nil {
+			return err
+		}
+		if result, ok := result.(*object.Error); ok {
+			return errors.New(result.Message)
+		}
+		return nil
+	})
+}
+
+func runEval(program *ast.Program, loop *eventloop.Loop, globals []Global) error {
+	env := object.NewEnvironment()
+	for _, v := range loop.Builtins() {
+		env.Set(v.Name, v.Builtin)
+	}
+	for _, g := range globals {
+		env.Set(g.Name, g.Value)
+	}
+
+	if result, ok := evaluator.Eval(program, env).(*object.Error); ok {
+		return errors.New(result.Message)
+	}
+
+	return loop.Run(func(fn object.Object) error {
+		if result, ok := evaluator.Apply(fn).(*object.Error); ok {
+			return errors.New(result.Message)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"monkey/eventloop"
+	"testing"
+)
+
+func TestUnannotatedPrograms(t *testing.T) {
+	tests := []string{
+		`let h = {1: "a"}; puts(h["x"]);`,
+		`puts(["x" in [1, 2], "x" in {1: 2}, "a" in #{1, 2}]);`,
+	}
+
+	for _, input := range tests {
+		for _, engine := range []string{EngineVM, EngineEval} {
+			if err := Run(input, engine, eventloop.New()); err != nil {
+				t.Errorf("%s: %q failed: %s", engine, input, err)
+			}
+		}
+	}
+}
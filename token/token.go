@@ -5,6 +5,8 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based line of the first character
+	Column  int // 1-based byte offset of the first character within its line
 }
 
 const (
@@ -29,15 +31,18 @@ const (
 	GT     = ">"
 	EQ     = "=="
 	NOT_EQ = "!="
+	PIPE   = "|>"
 
 	// Delimeters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOTDOT    = ".."
 
 	LPAREN   = "("
 	RPAREN   = ")"
 	LBRACE   = "{"
+	LSET     = "#{"
 	RBRACE   = "}"
 	LBRACKET = "["
 	RBRACKET = "]"
@@ -45,21 +50,41 @@ const (
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	YIELD    = "YIELD"
+	FOR      = "FOR"
+	IN       = "IN"
+	SPAWN    = "SPAWN"
+	SELECT   = "SELECT"
+	CASE     = "CASE"
+	DEFAULT  = "DEFAULT"
+	ENUM     = "ENUM"
+	IMPL     = "IMPL"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"const":   CONST,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"true":    TRUE,
+	"false":   FALSE,
+	"yield":   YIELD,
+	"for":     FOR,
+	"in":      IN,
+	"spawn":   SPAWN,
+	"enum":    ENUM,
+	"impl":    IMPL,
+	"select":  SELECT,
+	"case":    CASE,
+	"default": DEFAULT,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,931 @@
+package typecheck
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+	"strings"
+)
+
+// Var is a type variable of the inference pass. It stands for a type that is
+// not known yet and is bound by unification.
+type Var struct {
+	ID       int
+	Level    int  // the let nesting depth it was created at
+	Instance Type // the type it is bound to, nil while unknown
+}
+
+func (v *Var) String() string {
+	if v.Instance != nil {
+		return v.Instance.String()
+	}
+	return fmt.Sprintf("t%d", v.ID)
+}
+
+// Scheme is a polymorphic type. Each use of a let-bound function instantiates
+// its Vars afresh, so `let id = fn(x) { x }` works for ints and strings alike.
+type Scheme struct {
+	Vars []*Var
+	Type Type
+
+	operators map[*Var]string // vars that must support + < or > at each use
+}
+
+func (s *Scheme) String() string { return Describe(s.Type) }
+
+// Binding is the inferred type of a top-level let.
+type Binding struct {
+	Name string
+	Type Type
+}
+
+func (b Binding) String() string { return b.Name + ": " + Describe(b.Type) }
+
+// Describe formats a type with its unknown parts named a, b, c, ...
+func Describe(t Type) string {
+	return printer{}.print(t)
+}
+
+type printer map[*Var]string
+
+func (p printer) print(t Type) string {
+	switch t := prune(t).(type) {
+	case *Var:
+		name, ok := p[t]
+		if !ok {
+			name = string(rune('a' + len(p)))
+			if len(p) >= 26 {
+				name = fmt.Sprintf("t%d", len(p))
+			}
+			p[t] = name
+		}
+		return name
+	case *Scheme:
+		return p.print(t.Type)
+	case *Array:
+		return "[" + p.print(t.Elem) + "]"
+	case *Set:
+		return "#{" + p.print(t.Elem) + "}"
+	case *Hash:
+		return "{" + p.print(t.Key) + ": " + p.print(t.Value) + "}"
+	case *Tuple:
+		var elems []string
+		for _, e := range t.Elems {
+			elems = append(elems, p.print(e))
+		}
+		if len(elems) == 1 {
+			return "(" + elems[0] + ",)"
+		}
+		return "(" + strings.Join(elems, ", ") + ")"
+	case *Function:
+		out := "fn("
+		for i, param := range t.Params {
+			if i > 0 {
+				out += ", "
+			}
+			out += p.print(param)
+		}
+		return out + "): " + p.print(t.Return)
+	default:
+		return t.String()
+	}
+}
+
+// prune follows bound variables to the type they stand for.
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.Instance == nil {
+			return t
+		}
+		t = v.Instance
+	}
+}
+
+var builtinTypes = func() map[string]*Scheme {
+	a, b := &Var{}, &Var{}
+	poly := func(t Type) *Scheme { return &Scheme{Vars: []*Var{a, b}, Type: t} }
+	setOp := poly(&Function{Params: []Type{&Set{Elem: a}, &Set{Elem: a}}, Return: &Set{Elem: a}})
+
+	return map[string]*Scheme{
+		"len":          {Type: &Function{Params: []Type{Any}, Return: Int}},
+		"first":        poly(&Function{Params: []Type{&Array{Elem: a}}, Return: a}),
+		"last":         poly(&Function{Params: []Type{&Array{Elem: a}}, Return: a}),
+		"rest":         poly(&Function{Params: []Type{&Array{Elem: a}}, Return: &Array{Elem: a}}),
+		"push":         poly(&Function{Params: []Type{&Array{Elem: a}, a}, Return: &Array{Elem: a}}),
+		"append!":      poly(&Function{Params: []Type{&Array{Elem: a}, a}, Return: &Array{Elem: a}}),
+		"pop!":         poly(&Function{Params: []Type{&Array{Elem: a}}, Return: a}),
+		"insert!":      poly(&Function{Params: []Type{&Array{Elem: a}, Int, a}, Return: &Array{Elem: a}}),
+		"remove!":      poly(&Function{Params: []Type{&Array{Elem: a}, Int}, Return: a}),
+		"reverse!":     poly(&Function{Params: []Type{&Array{Elem: a}}, Return: &Array{Elem: a}}),
+		"sort_by":      poly(&Function{Params: []Type{&Array{Elem: a}, &Function{Params: []Type{a}, Return: b}}, Return: &Array{Elem: a}}),
+		"set":          poly(&Function{Params: []Type{&Array{Elem: a}}, Return: &Set{Elem: a}}),
+		"union":        setOp,
+		"intersection": setOp,
+		"difference":   setOp,
+		"tag":          {Type: &Function{Params: []Type{Any}, Return: String}},
+		"new":          {Type: &Function{Params: []Type{String, Any}, Return: Any}},
+		"to_string":    {Type: &Function{Params: []Type{Any}, Return: String}},
+		"split":        {Type: &Function{Params: []Type{String, String}, Return: &Array{Elem: String}}},
+		"join":         {Type: &Function{Params: []Type{&Array{Elem: String}, String}, Return: String}},
+		"trim":         {Type: &Function{Params: []Type{String}, Return: String}},
+		"upper":        {Type: &Function{Params: []Type{String}, Return: String}},
+		"lower":        {Type: &Function{Params: []Type{String}, Return: String}},
+		"replace":      {Type: &Function{Params: []Type{String, String, String}, Return: String}},
+		"contains":     {Type: &Function{Params: []Type{String, String}, Return: Bool}},
+		"starts_with":  {Type: &Function{Params: []Type{String, String}, Return: Bool}},
+		"ends_with":    {Type: &Function{Params: []Type{String, String}, Return: Bool}},
+		"index_of":     {Type: &Function{Params: []Type{String, String}, Return: Int}},
+		"repeat":       {Type: &Function{Params: []Type{String, Int}, Return: String}},
+		"chars":        {Type: &Function{Params: []Type{String}, Return: &Array{Elem: String}}},
+		"keys":         poly(&Function{Params: []Type{&Hash{Key: a, Value: b}}, Return: &Array{Elem: a}}),
+		"values":       poly(&Function{Params: []Type{&Hash{Key: a, Value: b}}, Return: &Array{Elem: b}}),
+		"entries":      poly(&Function{Params: []Type{&Hash{Key: a, Value: b}}, Return: &Array{Elem: &Tuple{Elems: []Type{a, b}}}}),
+		"delete":       poly(&Function{Params: []Type{&Hash{Key: a, Value: b}, a}, Return: &Hash{Key: a, Value: b}}),
+		"merge":        poly(&Function{Params: []Type{&Hash{Key: a, Value: b}, &Hash{Key: a, Value: b}}, Return: &Hash{Key: a, Value: b}}),
+		// the collection may be anything iterable, so only the callback
+		// ties the types together
+		"map":      poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: b}}, Return: &Array{Elem: b}}),
+		"filter":   poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: Any}}, Return: &Array{Elem: a}}),
+		"reduce":   poly(&Function{Params: []Type{Any, b, &Function{Params: []Type{b, a}, Return: b}}, Return: b}),
+		"each":     poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: Any}}, Return: Null}),
+		"any":      poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: Any}}, Return: Bool}),
+		"all":      poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: Any}}, Return: Bool}),
+		"find":     poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: Any}}, Return: a}),
+		"flat_map": poly(&Function{Params: []Type{Any, &Function{Params: []Type{a}, Return: &Array{Elem: b}}}, Return: &Array{Elem: b}}),
+		"subset":   poly(&Function{Params: []Type{&Set{Elem: a}, &Set{Elem: a}}, Return: Bool}),
+	}
+}()
+
+// operands records that the operands of + < or > must turn out to be ints or
+// strings. It is checked once the whole program has been seen.
+type operands struct {
+	tok      token.Token
+	operator string
+	typ      Type
+}
+
+// Inferrer infers the types of unannotated programs by unification, in the
+// style of Hindley-Milner, and reports the places where they do not agree.
+// Annotations are honoured, builtins without a precise type are any, and
+// top-level bindings are kept between calls to Infer.
+type Inferrer struct {
+	globals  *scope
+	scope    *scope
+	level    int
+	nextVar  int
+	returns  []Type
+	operands []operands
+	errors   []*Error
+	enums    map[string]*Enum
+}
+
+func NewInferrer() *Inferrer {
+	globals := newScope(nil)
+	for _, b := range object.Builtins {
+		if t, ok := builtinTypes[b.Name]; ok {
+			globals.store[b.Name] = t
+		} else {
+			globals.store[b.Name] = Any
+		}
+	}
+	return &Inferrer{globals: globals, scope: globals, enums: make(map[string]*Enum)}
+}
+
+// Define adds a global binding, such as a builtin injected by the host.
+func (in *Inferrer) Define(name string, t Type) {
+	in.globals.store[name] = t
+}
+
+// Infer infers a whole program with a fresh Inferrer.
+func Infer(program *ast.Program) ([]Binding, []*Error) {
+	return NewInferrer().Infer(program)
+}
+
+func (in *Inferrer) Infer(program *ast.Program) ([]Binding, []*Error) {
+	in.errors = nil
+	in.operands = nil
+	in.scope = in.globals
+
+	var bindings []Binding
+	for _, s := range program.Statements {
+		in.statement(s)
+		if let, ok := s.(*ast.LetStatement); ok {
+			names := let.Names
+			if let.Name != nil {
+				names = []*ast.Identifier{let.Name}
+			}
+			for _, name := range names {
+				t, _ := in.globals.find(name.Value)
+				bindings = append(bindings, Binding{Name: name.Value, Type: t})
+			}
+		}
+	}
+
+	for _, o := range in.operands {
+		t := prune(o.typ)
+		if _, ok := t.(*Var); ok || t == Any || t == Int || t == String {
+			continue
+		}
+		s := in.show(t)
+		in.errorf(o.tok, "unknown operator: %s %s %s", s[0], o.operator, s[0])
+	}
+	sort.SliceStable(in.errors, func(i, j int) bool {
+		a, b := in.errors[i], in.errors[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return bindings, in.errors
+}
+
+func (in *Inferrer) errorf(tok token.Token, format string, a ...interface{}) {
+	in.errors = append(in.errors, &Error{Line: tok.Line, Column: tok.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+// show formats types for one message, naming their unknown parts consistently.
+func (in *Inferrer) show(types ...Type) []string {
+	p := printer{}
+	var out []string
+	for _, t := range types {
+		out = append(out, p.print(t))
+	}
+	return out
+}
+
+func (in *Inferrer) annotation(a *ast.TypeAnnotation) Type {
+	t, err := FromAnnotation(a, in.enums)
+	if err != nil {
+		in.errorf(a.Token, "%s", err)
+		return Any
+	}
+	return t
+}
+
+func (in *Inferrer) fresh() *Var {
+	in.nextVar++
+	return &Var{ID: in.nextVar, Level: in.level}
+}
+
+func (in *Inferrer) enter() {
+	in.scope = newScope(in.scope)
+}
+
+func (in *Inferrer) leave() {
+	in.scope = in.scope.outer
+}
+
+// unify makes a and b the same type by binding variables, and reports whether
+// that was possible. Any unifies with everything without binding anything.
+func (in *Inferrer) unify(a, b Type) bool {
+	a, b = prune(a), prune(b)
+	if a == b || a == Any || b == Any {
+		return true
+	}
+	if v, ok := a.(*Var); ok {
+		return in.bind(v, b)
+	}
+	if v, ok := b.(*Var); ok {
+		return in.bind(v, a)
+	}
+
+	switch a := a.(type) {
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && in.unify(a.Elem, b.Elem)
+	case *Set:
+		b, ok := b.(*Set)
+		return ok && in.unify(a.Elem, b.Elem)
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && in.unify(a.Key, b.Key) && in.unify(a.Value, b.Value)
+	case *Tuple:
+		b, ok := b.(*Tuple)
+		if !ok || len(a.Elems) != len(b.Elems) {
+			return false
+		}
+		for i := range a.Elems {
+			if !in.unify(a.Elems[i], b.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *Function:
+		b, ok := b.(*Function)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !in.unify(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return in.unify(a.Return, b.Return)
+	}
+	return false
+}
+
+func (in *Inferrer) bind(v *Var, t Type) bool {
+	if occurs(v, t) {
+		return false
+	}
+	v.Instance = t
+	return true
+}
+
+// occurs reports whether v appears in t. It also moves the variables of t out
+// to v's level, since they now escape as far as v does.
+func occurs(v *Var, t Type) bool {
+	switch t := prune(t).(type) {
+	case *Var:
+		if t.Level > v.Level {
+			t.Level = v.Level
+		}
+		return t == v
+	case *Array:
+		return occurs(v, t.Elem)
+	case *Set:
+		return occurs(v, t.Elem)
+	case *Hash:
+		return occurs(v, t.Key) || occurs(v, t.Value)
+	case *Tuple:
+		for _, e := range t.Elems {
+			if occurs(v, e) {
+				return true
+			}
+		}
+		return false
+	case *Function:
+		for _, p := range t.Params {
+			if occurs(v, p) {
+				return true
+			}
+		}
+		return occurs(v, t.Return)
+	}
+	return false
+}
+
+// generalize turns the variables created inside the current let into the
+// quantified variables of a scheme.
+func (in *Inferrer) generalize(t Type) Type {
+	var vars []*Var
+	seen := make(map[*Var]bool)
+	var collect func(t Type)
+	collect = func(t Type) {
+		switch t := prune(t).(type) {
+		case *Var:
+			if t.Level > in.level && !seen[t] {
+				seen[t] = true
+				vars = append(vars, t)
+			}
+		case *Array:
+			collect(t.Elem)
+		case *Set:
+			collect(t.Elem)
+		case *Hash:
+			collect(t.Key)
+			collect(t.Value)
+		case *Tuple:
+			for _, e := range t.Elems {
+				collect(e)
+			}
+		case *Function:
+			for _, p := range t.Params {
+				collect(p)
+			}
+			collect(t.Return)
+		}
+	}
+	collect(t)
+	if len(vars) == 0 {
+		return t
+	}
+	scheme := &Scheme{Vars: vars, Type: t, operators: make(map[*Var]string)}
+	for _, o := range in.operands {
+		if v, ok := prune(o.typ).(*Var); ok && seen[v] {
+			scheme.operators[v] = o.operator
+		}
+	}
+	return scheme
+}
+
+// instantiate gives the scheme fresh variables for a use at tok.
+func (in *Inferrer) instantiate(tok token.Token, s *Scheme) Type {
+	fresh := make(map[*Var]Type)
+	for _, v := range s.Vars {
+		fresh[v] = in.fresh()
+		if operator, ok := s.operators[v]; ok {
+			in.operands = append(in.operands, operands{tok: tok, operator: operator, typ: fresh[v]})
+		}
+	}
+	var substitute func(t Type) Type
+	substitute = func(t Type) Type {
+		switch t := prune(t).(type) {
+		case *Var:
+			if r, ok := fresh[t]; ok {
+				return r
+			}
+			return t
+		case *Array:
+			return &Array{Elem: substitute(t.Elem)}
+		case *Set:
+			return &Set{Elem: substitute(t.Elem)}
+		case *Hash:
+			return &Hash{Key: substitute(t.Key), Value: substitute(t.Value)}
+		case *Tuple:
+			tuple := &Tuple{}
+			for _, e := range t.Elems {
+				tuple.Elems = append(tuple.Elems, substitute(e))
+			}
+			return tuple
+		case *Function:
+			fn := &Function{Return: substitute(t.Return)}
+			for _, p := range t.Params {
+				fn.Params = append(fn.Params, substitute(p))
+			}
+			return fn
+		default:
+			return t
+		}
+	}
+	return substitute(s.Type)
+}
+
+func (in *Inferrer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		in.expression(s.Expression)
+
+	case *ast.LetStatement:
+		if s.Names != nil {
+			in.destructure(s)
+			break
+		}
+		name := s.Name.Value
+		fl, isFunction := s.Value.(*ast.FunctionLiteral)
+		if isFunction {
+			in.level++
+			self := in.fresh()
+			in.scope.store[name] = self
+			in.unify(self, in.expression(fl))
+		}
+
+		var t Type
+		if isFunction {
+			t, _ = in.scope.find(name)
+		} else {
+			t = in.expression(s.Value)
+		}
+		if s.Type != nil {
+			want := in.annotation(s.Type)
+			if !in.unify(want, t) {
+				str := in.show(t, want)
+				in.errorf(s.Name.Token, "cannot use %s as %s in let %s", str[0], str[1], name)
+			}
+			if !isFunction {
+				t = want
+			}
+		}
+
+		if isFunction {
+			in.level--
+			t = in.generalize(t)
+		}
+		in.scope.store[name] = t
+
+	case *ast.ReturnStatement:
+		got := in.expression(s.ReturnValue)
+		if len(in.returns) > 0 {
+			want := in.returns[len(in.returns)-1]
+			if !in.unify(want, got) {
+				str := in.show(want, got)
+				in.errorf(s.Token, "function returns both %s and %s", str[0], str[1])
+			}
+		}
+
+	case *ast.EnumStatement:
+		enum := &Enum{Name: s.Name.Value}
+		in.enums[enum.Name] = enum
+		for _, v := range s.Variants {
+			in.scope.store[v.Name.Value] = variantType(enum, v)
+		}
+
+	case *ast.ImplStatement:
+		// the methods have unrelated types, so they are not unified as the
+		// values of an ordinary hash would be
+		if hash, ok := s.Methods.(*ast.HashLiteral); ok {
+			for _, key := range hash.Keys {
+				in.expression(key)
+				in.expression(hash.Pairs[key])
+			}
+		} else {
+			in.expression(s.Methods)
+		}
+		if enum, ok := in.enums[s.Name.Value]; ok {
+			enum.Impl = true
+		}
+
+	case *ast.ForStatement:
+		elem := elements(prune(in.expression(s.Iterable)), 1)
+		in.enter()
+		in.scope.store[s.Variable.Value] = elem[0]
+		in.block(s.Body)
+		in.leave()
+	}
+}
+
+// block infers a block in its own scope and returns the type of its value.
+func (in *Inferrer) block(b *ast.BlockStatement) Type {
+	in.enter()
+	defer in.leave()
+
+	var result Type = Null
+	for _, s := range b.Statements {
+		switch s := s.(type) {
+		case *ast.ExpressionStatement:
+			result = in.expression(s.Expression)
+		case *ast.ReturnStatement:
+			// Control never falls out of the block, so its value can be anything.
+			in.statement(s)
+			result = in.fresh()
+		default:
+			// A block ending in a let or a loop is null, but functions often
+			// fall off the end of one as their "nothing found" case.
+			in.statement(s)
+			result = Any
+		}
+	}
+	return result
+}
+
+func (in *Inferrer) function(fl *ast.FunctionLiteral) Type {
+	fn := &Function{}
+	in.enter()
+	for i, p := range fl.Parameters {
+		var t Type = in.fresh()
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			t = in.annotation(fl.ParameterTypes[i])
+		}
+		in.scope.store[p.Value] = t
+		fn.Params = append(fn.Params, t)
+	}
+
+	var ret Type = in.fresh()
+	if fl.ReturnType != nil {
+		ret = in.annotation(fl.ReturnType)
+	}
+	in.returns = append(in.returns, ret)
+	got := in.block(fl.Body)
+	in.returns = in.returns[:len(in.returns)-1]
+	in.leave()
+
+	if fl.IsGenerator {
+		fn.Return = Any
+		return fn
+	}
+	if !in.unify(ret, got) {
+		str := in.show(ret, got)
+		in.errorf(fl.Token, "function returns both %s and %s", str[0], str[1])
+	}
+	fn.Return = ret
+	return fn
+}
+
+func (in *Inferrer) expression(e ast.Expression) Type {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.Identifier:
+		t, ok := in.scope.find(e.Value)
+		if !ok {
+			in.errorf(e.Token, "identifier not found: %s", e.Value)
+			return Any
+		}
+		if s, ok := t.(*Scheme); ok {
+			return in.instantiate(e.Token, s)
+		}
+		return t
+
+	case *ast.PrefixExpression:
+		right := in.expression(e.Right)
+		if e.Operator == "!" {
+			return Bool
+		}
+		if !in.unify(Int, right) {
+			in.errorf(e.Token, "unknown operator: %s%s", e.Operator, in.show(right)[0])
+		}
+		return Int
+
+	case *ast.InfixExpression:
+		return in.infix(e)
+
+	case *ast.PipeExpression:
+		left := in.expression(e.Left)
+		callee := e.Right
+		var args []ast.Expression
+		if call, ok := e.Right.(*ast.CallExpression); ok {
+			callee, args = call.Function, call.Arguments
+		}
+		return in.call(e.Token, in.expression(callee), append([]Type{left}, in.expressions(args)...))
+
+	case *ast.CallExpression:
+		return in.call(e.Token, in.expression(e.Function), in.expressions(e.Arguments))
+
+	case *ast.IfExpression:
+		in.expression(e.Condition)
+		consequence := in.block(e.Consequence)
+		if e.Alternative == nil {
+			return Any
+		}
+		alternative := in.block(e.Alternative)
+		if !in.unify(consequence, alternative) {
+			str := in.show(consequence, alternative)
+			in.errorf(e.Token, "if branches have different types: %s and %s", str[0], str[1])
+			return Any
+		}
+		return consequence
+
+	case *ast.FunctionLiteral:
+		return in.function(e)
+
+	case *ast.ArrayLiteral:
+		return &Array{Elem: in.common(e.Token, "array elements", e.Elements)}
+
+	case *ast.SetLiteral:
+		return &Set{Elem: in.common(e.Token, "set elements", e.Elements)}
+
+	case *ast.TupleLiteral:
+		tuple := &Tuple{}
+		for _, el := range e.Elements {
+			tuple.Elems = append(tuple.Elems, in.expression(el))
+		}
+		return tuple
+
+	case *ast.HashLiteral:
+		var values []ast.Expression
+		for _, k := range e.Keys {
+			values = append(values, e.Pairs[k])
+		}
+		return &Hash{
+			Key:   in.common(e.Token, "hash keys", e.Keys),
+			Value: in.common(e.Token, "hash values", values),
+		}
+
+	case *ast.IndexExpression:
+		return in.index(e)
+
+	case *ast.SliceExpression:
+		left := in.expression(e.Left)
+		for _, bound := range []ast.Expression{e.Start, e.End} {
+			if bound == nil {
+				continue
+			}
+			if t := in.expression(bound); !in.unify(Int, t) {
+				in.errorf(e.Token, "slice bounds must be int, got %s", in.show(t)[0])
+			}
+		}
+		return left
+
+	case *ast.ArrayComprehension:
+		in.enter()
+		defer in.leave()
+		in.clause(e.Clause)
+		return &Array{Elem: in.expression(e.Element)}
+
+	case *ast.HashComprehension:
+		in.enter()
+		defer in.leave()
+		in.clause(e.Clause)
+		return &Hash{Key: in.expression(e.Key), Value: in.expression(e.Value)}
+
+	case *ast.YieldExpression:
+		in.expression(e.Value)
+		return Any
+
+	case *ast.SpawnExpression:
+		in.call(e.Token, in.expression(e.Function), in.expressions(e.Arguments))
+		return Null
+
+	case *ast.SelectExpression:
+		for _, sc := range e.Cases {
+			in.expression(sc.Channel)
+			if sc.Value != nil {
+				in.expression(sc.Value)
+			}
+			in.enter()
+			if sc.Variable != nil {
+				in.scope.store[sc.Variable.Value] = Any
+			}
+			in.block(sc.Body)
+			in.leave()
+		}
+		if e.Default != nil {
+			in.block(e.Default)
+		}
+		return Any
+	}
+	return Any
+}
+
+func (in *Inferrer) expressions(exps []ast.Expression) []Type {
+	var types []Type
+	for _, e := range exps {
+		types = append(types, in.expression(e))
+	}
+	return types
+}
+
+// common unifies the types of all expressions, which are described by what
+// in the error message. Only the first mismatch is reported.
+func (in *Inferrer) common(tok token.Token, what string, exps []ast.Expression) Type {
+	t := in.fresh()
+	reported := false
+	for _, e := range exps {
+		got := in.expression(e)
+		if !in.unify(t, got) && !reported {
+			str := in.show(t, got)
+			in.errorf(tok, "%s have different types: %s and %s", what, str[0], str[1])
+			reported = true
+		}
+	}
+	return t
+}
+
+func (in *Inferrer) infix(e *ast.InfixExpression) Type {
+	left := in.expression(e.Left)
+	right := in.expression(e.Right)
+
+	switch e.Operator {
+	case "==", "!=":
+		return Bool
+	case "in":
+		in.membership(e, left, right)
+		return Bool
+	case "..":
+		if !in.unify(Int, left) || !in.unify(Int, right) {
+			str := in.show(left, right)
+			in.errorf(e.Token, "unsupported types for range: %s..%s", str[0], str[1])
+		}
+		return Range
+	}
+
+	if implemented(prune(left)) || implemented(prune(right)) {
+		return Any
+	}
+	if !in.unify(left, right) {
+		str := in.show(left, right)
+		in.errorf(e.Token, "type mismatch: %s %s %s", str[0], e.Operator, str[1])
+		return Any
+	}
+
+	switch e.Operator {
+	case "+":
+		in.operands = append(in.operands, operands{tok: e.Token, operator: e.Operator, typ: left})
+		return left
+	case "<", ">":
+		in.operands = append(in.operands, operands{tok: e.Token, operator: e.Operator, typ: left})
+		return Bool
+	}
+	if !in.unify(Int, left) {
+		str := in.show(left)
+		in.errorf(e.Token, "unknown operator: %s %s %s", str[0], e.Operator, str[0])
+	}
+	return Int
+}
+
+// membership checks `element in container` once the container is known.
+func (in *Inferrer) membership(e *ast.InfixExpression, element, container Type) {
+	var want Type = Any
+	switch c := prune(container).(type) {
+	case *Array:
+		want = c.Elem
+	case *Set:
+		want = c.Elem
+	case *Hash:
+		want = c.Key
+	case *Basic:
+		switch c {
+		case String:
+			want = String
+		case Range:
+			want = Int
+		case Any:
+		default:
+			str := in.show(element, c)
+			in.errorf(e.Token, "unknown operator: %s in %s", str[0], str[1])
+			return
+		}
+	}
+	if !in.unify(want, element) {
+		str := in.show(element, container)
+		in.errorf(e.Token, "cannot look for %s in %s", str[0], str[1])
+	}
+}
+
+func (in *Inferrer) call(tok token.Token, callee Type, args []Type) Type {
+	switch fn := prune(callee).(type) {
+	case *Function:
+		if len(args) != len(fn.Params) {
+			in.errorf(tok, "wrong number of arguments: want=%d, got=%d", len(fn.Params), len(args))
+			return fn.Return
+		}
+		for i, arg := range args {
+			if !in.unify(fn.Params[i], arg) {
+				str := in.show(arg, fn.Params[i])
+				in.errorf(tok, "cannot use %s as %s in argument %d", str[0], str[1], i+1)
+			}
+		}
+		return fn.Return
+	case *Var:
+		ret := in.fresh()
+		if !in.unify(fn, &Function{Params: args, Return: ret}) {
+			// Only possible when the callee occurs in its own arguments.
+			in.errorf(tok, "cannot infer a finite type for this call")
+		}
+		return ret
+	}
+	if callee == Any {
+		return Any
+	}
+	in.errorf(tok, "not a function: %s", in.show(callee)[0])
+	return Any
+}
+
+func (in *Inferrer) index(e *ast.IndexExpression) Type {
+	left := prune(in.expression(e.Left))
+	index := in.expression(e.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !in.unify(Int, index) {
+			in.errorf(e.Token, "array index must be int, got %s", in.show(index)[0])
+		}
+		return left.Elem
+	case *Hash:
+		if !in.unify(left.Key, index) {
+			str := in.show(index, left.Key)
+			in.errorf(e.Token, "cannot use %s as %s hash key", str[0], str[1])
+		}
+		return left.Value
+	case *Tuple:
+		if !in.unify(Int, index) {
+			in.errorf(e.Token, "tuple index must be int, got %s", in.show(index)[0])
+		}
+		return tupleIndex(left, e.Index)
+	}
+	if left == String {
+		if !in.unify(Int, index) {
+			in.errorf(e.Token, "string index must be int, got %s", in.show(index)[0])
+		}
+		return String
+	}
+	return Any
+}
+
+// destructure binds the names of `let (a, b) = ...`. A value of unknown type
+// is taken to be a tuple with as many elements as there are names.
+func (in *Inferrer) destructure(s *ast.LetStatement) {
+	got := prune(in.expression(s.Value))
+	elems := make([]Type, len(s.Names))
+	switch t := got.(type) {
+	case *Array:
+		for i := range elems {
+			elems[i] = t.Elem
+		}
+	default:
+		if got == Any {
+			for i := range elems {
+				elems[i] = Any
+			}
+			break
+		}
+		for i := range elems {
+			elems[i] = in.fresh()
+		}
+		if !in.unify(&Tuple{Elems: elems}, got) {
+			in.errorf(s.Token, "cannot unpack %s into %d values", in.show(got)[0], len(s.Names))
+			for i := range elems {
+				elems[i] = Any
+			}
+		}
+	}
+	for i, name := range s.Names {
+		in.scope.store[name.Value] = elems[i]
+	}
+}
+
+// clause binds the variables of a comprehension clause in the current scope.
+func (in *Inferrer) clause(cl *ast.ComprehensionClause) {
+	elems := elements(prune(in.expression(cl.Iterable)), len(cl.Variables))
+	for i, v := range cl.Variables {
+		in.scope.store[v.Value] = elems[i]
+	}
+	if cl.Condition != nil {
+		in.expression(cl.Condition)
+	}
+}
@@ -0,0 +1,117 @@
+package typecheck
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func infer(t *testing.T, input string) ([]Binding, []*Error) {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Infer(program)
+}
+
+func TestInferredTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = 5`, "int"},
+		{`let x = "a" + "b"`, "string"},
+		{`let x = 1 < 2`, "bool"},
+		{`let x = [1, 2, 3]`, "[int]"},
+		{`let x = []`, "[a]"},
+		{`let x = {"a": [true]}`, "{string: [bool]}"},
+		{`let x = #{"a"}`, "#{string}"},
+		{`let x = 0..10`, "range"},
+		{`let x = fn(a) { a }`, "fn(a): a"},
+		{`let x = fn(a, b) { a + b }`, "fn(a, a): a"},
+		{`let x = fn(a, b) { a - b }`, "fn(int, int): int"},
+		{`let x = fn(f, a) { f(f(a)) }`, "fn(fn(a): a, a): a"},
+		{`let x = fn(a) { if (a) { 1 } else { 2 } }`, "fn(a): int"},
+		{`let x = fn(arr) { first(arr) * 2 }`, "fn([int]): int"},
+		{`let x = fn(h, k) { h[k] }`, "fn(a, b): c"},
+		{`let x = fn(n) { if (n < 2) { return n } x(n - 1) + x(n - 2) }`, "fn(int): int"},
+		{`let id = fn(a) { a }; let x = [id(1), id(2)]`, "[int]"},
+		{`let id = fn(a) { a }; id("s"); let x = id`, "fn(a): a"},
+		{`let x = [s + "!" for s in ["a", "b"]]`, "[string]"},
+		{`let x = {k: v > 1 for k, v in {"a": 1}}`, "{string: bool}"},
+		{`let x = fn(a: string) { a }`, "fn(string): string"},
+		{`let x: any = 5`, "any"},
+		{`enum Shape { Circle(r), Empty }; let x = [Circle(1), Empty]`, "[Shape]"},
+		{`enum Shape { Circle(r) }; let x = fn(s: Shape) { tag(s) }`, "fn(Shape): string"},
+		{`let x = fn(xs) { 1 in xs }`, "fn(a): bool"},
+		{`let x = fn(s) { "a" in [s] }`, "fn(string): bool"},
+		{`enum Money { Cents(n) }; impl Money { "+": fn(a, b) { a }, "len": fn(m) { 1 } }; let x = Cents(1) + Cents(2)`, "any"},
+		{`let x = to_string(new("Point", {}))`, "string"},
+		{`let x = (1, "a")`, "(int, string)"},
+		{`let swap = fn(a, b) { return b, a }; let x = swap(1, "a")`, "(string, int)"},
+		{`let (a, x) = (1, [true])`, "[bool]"},
+		{`let x = fn(t) { let (a, b) = t; a + 1 }`, "fn((int, a)): int"},
+		{`let x = fn(s) { split(s, ",") |> join("-") }`, "fn(string): string"},
+		{`let x = map([1, 2], fn(n) { n > 1 })`, "[bool]"},
+		{`let x = fn(xs) { reduce(xs, 0, fn(acc, n) { acc + n }) }`, "fn(a): int"},
+		{`let x = entries({"a": 1})`, "[(string, int)]"},
+		{`let x = sort_by(["a"], len)`, "[string]"},
+		{`let compose = fn(f, g) { fn(x) { g(f(x)) } }; let x = compose(fn(a) { a * 2 }, fn(b) { b > 3 })`, "fn(int): bool"},
+	}
+
+	for _, tt := range tests {
+		bindings, errs := infer(t, tt.input)
+		if len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", tt.input, errs)
+			continue
+		}
+		last := bindings[len(bindings)-1]
+		if last.Name != "x" {
+			t.Fatalf("last binding is not x. got=%s", last.Name)
+		}
+		if got := Describe(last.Type); got != tt.expected {
+			t.Errorf("wrong type for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestInferenceErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`if (true) { 1 } else { "one" }`, `1:1: if branches have different types: int and string`},
+		{`let x = 5; x(1)`, `1:13: not a function: int`},
+		{`[1, "two", true]`, `1:1: array elements have different types: int and string`},
+		{`{"a": 1, "b": "c"}`, `1:1: hash values have different types: int and string`},
+		{`1 + "a"`, `1:3: type mismatch: int + string`},
+		{`"a" * "b"`, `1:5: unknown operator: string * string`},
+		{`let f = fn(a) { a * 2 }; f("x")`, `1:27: cannot use string as int in argument 1`},
+		{`let f = fn(a, b) { a }; f(1)`, `1:26: wrong number of arguments: want=2, got=1`},
+		{`let add = fn(a, b) { a + b }; add(true, false)`, `1:31: unknown operator: bool + bool`},
+		{`fn(a) { if (a) { return 1 } "one" }`, `1:1: function returns both int and string`},
+		{`let f = fn(x) { x(x) }`, `1:18: cannot infer a finite type for this call`},
+		{`[1, 2]["a"]`, `1:7: array index must be int, got string`},
+		{`"a" in [1, 2]`, `1:5: cannot look for string in [int]`},
+		{`enum Shape { Circle(r) }; enum Color { Red }; [Circle(1), Red]`, `1:47: array elements have different types: Shape and Color`},
+		{`let (a, b) = (1, 2, 3)`, `1:1: cannot unpack (int, int, int) into 2 values`},
+		{`upper(1)`, `1:6: cannot use int as string in argument 1`},
+		{`map([1], fn(a, b) { a })`, `1:4: cannot use fn(a, b): a as fn(c): d in argument 2`},
+		{`let x: int = "a"`, `1:5: cannot use string as int in let x`},
+		{`undefined + 1`, `1:1: identifier not found: undefined`},
+		{"let apply = fn(f) { f(1) };\napply(fn(s) { s + \"!\" })", `2:6: cannot use fn(string): string as fn(int): a in argument 1`},
+	}
+
+	for _, tt := range tests {
+		_, errs := infer(t, tt.input)
+		if len(errs) != 1 {
+			t.Errorf("wrong number of errors for %q. want=1, got=%d (%v)", tt.input, len(errs), errs)
+			continue
+		}
+		if errs[0].Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errs[0].Error())
+		}
+	}
+}
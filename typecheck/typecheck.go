@@ -0,0 +1,607 @@
+// Package typecheck finds type errors in a parsed program before it runs.
+//
+// Checking is gradual: anything that is neither annotated nor obvious from a
+// literal has type any, which is compatible with every type. Unannotated
+// programs therefore only fail on mistakes that would fail at runtime anyway,
+// such as adding a string to an integer.
+package typecheck
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/token"
+)
+
+// Error is a type error at a position in the source.
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+type scope struct {
+	store  map[string]Type
+	consts map[string]bool // names bound by const in this scope itself
+	outer  *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{store: make(map[string]Type), consts: make(map[string]bool), outer: outer}
+}
+
+func (s *scope) lookup(name string) Type {
+	if t, ok := s.find(name); ok {
+		return t
+	}
+	return Any
+}
+
+func (s *scope) find(name string) (Type, bool) {
+	for ; s != nil; s = s.outer {
+		if t, ok := s.store[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Checker checks programs. Top-level bindings are kept between calls to
+// Check so a REPL can check one line at a time.
+type Checker struct {
+	globals *scope
+	scope   *scope
+	returns []Type // declared result types of the enclosing functions
+	errors  []*Error
+
+	signatures map[*ast.FunctionLiteral]*Function
+	enums      map[string]*Enum
+}
+
+func New() *Checker {
+	globals := newScope(nil)
+	return &Checker{
+		globals:    globals,
+		scope:      globals,
+		signatures: make(map[*ast.FunctionLiteral]*Function),
+		enums:      make(map[string]*Enum),
+	}
+}
+
+// Check checks a whole program with a fresh Checker.
+func Check(program *ast.Program) []*Error {
+	return New().Check(program)
+}
+
+func (c *Checker) Check(program *ast.Program) []*Error {
+	c.errors = nil
+	c.scope = c.globals
+	c.returns = nil
+	for _, s := range program.Statements {
+		c.statement(s)
+	}
+	return c.errors
+}
+
+func (c *Checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Line: tok.Line, Column: tok.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *Checker) annotation(a *ast.TypeAnnotation) Type {
+	t, err := FromAnnotation(a, c.enums)
+	if err != nil {
+		c.errorf(a.Token, "%s", err)
+		return Any
+	}
+	return t
+}
+
+func (c *Checker) enter() {
+	c.scope = newScope(c.scope)
+}
+
+func (c *Checker) leave() {
+	c.scope = c.scope.outer
+}
+
+func (c *Checker) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		c.expression(s.Expression)
+
+	case *ast.LetStatement:
+		if s.Names != nil {
+			c.destructure(s)
+			break
+		}
+		c.declare(s.Name)
+		var want Type = Any
+		if s.Type != nil {
+			want = c.annotation(s.Type)
+		}
+		if fl, ok := s.Value.(*ast.FunctionLiteral); ok {
+			// Let the body see its own signature so recursive calls are checked.
+			if s.Type == nil {
+				want = c.signature(fl)
+			}
+			c.scope.store[s.Name.Value] = want
+		}
+		got := c.expression(s.Value)
+		if !Compatible(want, got) {
+			c.errorf(s.Name.Token, "cannot use %s as %s in let %s", got, want, s.Name.Value)
+		}
+		if want == Any {
+			want = got
+		}
+		c.scope.store[s.Name.Value] = want
+		if s.Token.Type == token.CONST {
+			c.scope.consts[s.Name.Value] = true
+		}
+
+	case *ast.ReturnStatement:
+		got := c.expression(s.ReturnValue)
+		if len(c.returns) > 0 {
+			want := c.returns[len(c.returns)-1]
+			if !Compatible(want, got) {
+				c.errorf(s.Token, "cannot return %s from a function returning %s", got, want)
+			}
+		}
+
+	case *ast.EnumStatement:
+		enum := &Enum{Name: s.Name.Value}
+		c.enums[enum.Name] = enum
+		for _, v := range s.Variants {
+			c.declare(v.Name)
+			c.scope.store[v.Name.Value] = variantType(enum, v)
+		}
+
+	case *ast.ImplStatement:
+		c.expression(s.Methods)
+		if enum, ok := c.enums[s.Name.Value]; ok {
+			enum.Impl = true
+		}
+
+	case *ast.ForStatement:
+		elem := elements(c.expression(s.Iterable), 1)
+		c.enter()
+		c.scope.store[s.Variable.Value] = elem[0]
+		c.block(s.Body)
+		c.leave()
+	}
+}
+
+// block checks a block in its own scope and returns the type of its value.
+func (c *Checker) block(b *ast.BlockStatement) Type {
+	c.enter()
+	defer c.leave()
+
+	var result Type = Null
+	for _, s := range b.Statements {
+		switch s := s.(type) {
+		case *ast.ExpressionStatement:
+			result = c.expression(s.Expression)
+		case *ast.ReturnStatement:
+			// Control never falls out of the block, so its value is moot.
+			c.statement(s)
+			result = Any
+		default:
+			c.statement(s)
+			result = Null
+		}
+	}
+	return result
+}
+
+// signature is the type of a function literal as written.
+func (c *Checker) signature(fl *ast.FunctionLiteral) *Function {
+	if fn, ok := c.signatures[fl]; ok {
+		return fn
+	}
+	fn := &Function{Return: Any}
+	for i := range fl.Parameters {
+		var t Type = Any
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			t = c.annotation(fl.ParameterTypes[i])
+		}
+		fn.Params = append(fn.Params, t)
+	}
+	if fl.ReturnType != nil {
+		fn.Return = c.annotation(fl.ReturnType)
+	}
+	c.signatures[fl] = fn
+	return fn
+}
+
+func (c *Checker) function(fl *ast.FunctionLiteral) Type {
+	fn := c.signature(fl)
+	c.enter()
+	for i, p := range fl.Parameters {
+		c.scope.store[p.Value] = fn.Params[i]
+	}
+	c.returns = append(c.returns, fn.Return)
+	got := c.block(fl.Body)
+	c.returns = c.returns[:len(c.returns)-1]
+	c.leave()
+
+	if !fl.IsGenerator && !Compatible(fn.Return, got) {
+		c.errorf(fl.Token, "cannot return %s from a function returning %s", got, fn.Return)
+	}
+	return fn
+}
+
+func (c *Checker) expression(e ast.Expression) Type {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.Identifier:
+		return c.scope.lookup(e.Value)
+
+	case *ast.PrefixExpression:
+		right := c.expression(e.Right)
+		if e.Operator == "!" {
+			return Bool
+		}
+		if !Compatible(Int, right) {
+			c.errorf(e.Token, "unknown operator: %s%s", e.Operator, right)
+		}
+		return Int
+
+	case *ast.InfixExpression:
+		return c.infix(e)
+
+	case *ast.PipeExpression:
+		left := c.expression(e.Left)
+		callee := e.Right
+		var args []ast.Expression
+		if call, ok := e.Right.(*ast.CallExpression); ok {
+			callee, args = call.Function, call.Arguments
+		}
+		return c.call(e.Token, c.expression(callee), append([]Type{left}, c.expressions(args)...))
+
+	case *ast.CallExpression:
+		return c.call(e.Token, c.expression(e.Function), c.expressions(e.Arguments))
+
+	case *ast.IfExpression:
+		c.expression(e.Condition)
+		consequence := c.block(e.Consequence)
+		if e.Alternative == nil {
+			return Any
+		}
+		return join(consequence, c.block(e.Alternative))
+
+	case *ast.FunctionLiteral:
+		return c.function(e)
+
+	case *ast.ArrayLiteral:
+		return &Array{Elem: c.common(e.Elements)}
+
+	case *ast.SetLiteral:
+		return &Set{Elem: c.common(e.Elements)}
+
+	case *ast.TupleLiteral:
+		tuple := &Tuple{}
+		for _, el := range e.Elements {
+			tuple.Elems = append(tuple.Elems, c.expression(el))
+		}
+		return tuple
+
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, k := range e.Keys {
+			key = join(key, c.expression(k))
+			value = join(value, c.expression(e.Pairs[k]))
+		}
+		if key == nil {
+			return &Hash{Key: Any, Value: Any}
+		}
+		return &Hash{Key: key, Value: value}
+
+	case *ast.IndexExpression:
+		return c.index(e)
+
+	case *ast.SliceExpression:
+		left := c.expression(e.Left)
+		for _, bound := range []ast.Expression{e.Start, e.End} {
+			if bound == nil {
+				continue
+			}
+			if t := c.expression(bound); !Compatible(Int, t) {
+				c.errorf(e.Token, "slice bounds must be int, got %s", t)
+			}
+		}
+		return left
+
+	case *ast.ArrayComprehension:
+		c.enter()
+		defer c.leave()
+		c.clause(e.Clause)
+		return &Array{Elem: c.expression(e.Element)}
+
+	case *ast.HashComprehension:
+		c.enter()
+		defer c.leave()
+		c.clause(e.Clause)
+		return &Hash{Key: c.expression(e.Key), Value: c.expression(e.Value)}
+
+	case *ast.YieldExpression:
+		c.expression(e.Value)
+		return Any
+
+	case *ast.SpawnExpression:
+		c.call(e.Token, c.expression(e.Function), c.expressions(e.Arguments))
+		return Null
+
+	case *ast.SelectExpression:
+		var result Type
+		for _, sc := range e.Cases {
+			c.expression(sc.Channel)
+			if sc.Value != nil {
+				c.expression(sc.Value)
+			}
+			c.enter()
+			if sc.Variable != nil {
+				c.scope.store[sc.Variable.Value] = Any
+			}
+			result = join(result, c.block(sc.Body))
+			c.leave()
+		}
+		if e.Default != nil {
+			result = join(result, c.block(e.Default))
+		}
+		if result == nil {
+			return Any
+		}
+		return result
+	}
+	return Any
+}
+
+func (c *Checker) expressions(exps []ast.Expression) []Type {
+	var types []Type
+	for _, e := range exps {
+		types = append(types, c.expression(e))
+	}
+	return types
+}
+
+// common is the type shared by all elements, any if they differ.
+func (c *Checker) common(exps []ast.Expression) Type {
+	var t Type
+	for _, e := range exps {
+		t = join(t, c.expression(e))
+	}
+	if t == nil {
+		return Any
+	}
+	return t
+}
+
+func (c *Checker) infix(e *ast.InfixExpression) Type {
+	left := c.expression(e.Left)
+	right := c.expression(e.Right)
+
+	switch e.Operator {
+	case "==", "!=":
+		return Bool
+	case "in":
+		c.membership(e, left, right)
+		return Bool
+	case "..":
+		if !Compatible(Int, left) || !Compatible(Int, right) {
+			c.errorf(e.Token, "unsupported types for range: %s..%s", left, right)
+		}
+		return Range
+	}
+
+	if implemented(left) || implemented(right) {
+		return Any
+	}
+	if left != Any && right != Any && left.String() != right.String() {
+		c.errorf(e.Token, "type mismatch: %s %s %s", left, e.Operator, right)
+		return Any
+	}
+	t := left
+	if t == Any {
+		t = right
+	}
+
+	switch {
+	case t == Any:
+		if e.Operator == "<" || e.Operator == ">" {
+			return Bool
+		}
+		return Any
+	case t == Int:
+		if e.Operator == "<" || e.Operator == ">" {
+			return Bool
+		}
+		return Int
+	case t == String && e.Operator == "+":
+		return String
+	case t == String && (e.Operator == "<" || e.Operator == ">"):
+		return Bool
+	}
+	c.errorf(e.Token, "unknown operator: %s %s %s", left, e.Operator, right)
+	return Any
+}
+
+// membership checks `element in container` for the containers it knows.
+// Looking for a value of another type is fine, the answer is just false,
+// unless an annotation declared what the container holds.
+func (c *Checker) membership(e *ast.InfixExpression, element, container Type) {
+	var want Type = Any
+	switch container := container.(type) {
+	case *Array:
+		if container.Declared {
+			want = container.Elem
+		}
+	case *Set:
+		if c.key(e.Token, element, "set element") && container.Declared {
+			want = container.Elem
+		}
+	case *Hash:
+		if c.key(e.Token, element, "hash key") && container.Declared {
+			want = container.Key
+		}
+	case *Basic:
+		switch container {
+		case String:
+			want = String
+		case Range:
+			want = Int
+		case Any:
+		default:
+			c.errorf(e.Token, "unknown operator: %s in %s", element, container)
+			return
+		}
+	}
+	if !Compatible(want, element) {
+		c.errorf(e.Token, "cannot look for %s in %s", element, container)
+	}
+}
+
+// key reports a value of type t used as what, a hash key or set element,
+// when it cannot be hashed. It returns false after reporting.
+func (c *Checker) key(tok token.Token, t Type, what string) bool {
+	if unhashable(t) {
+		c.errorf(tok, "unusable as %s: %s", what, t)
+		return false
+	}
+	return true
+}
+
+func (c *Checker) call(tok token.Token, callee Type, args []Type) Type {
+	if callee == Any {
+		return Any
+	}
+	fn, ok := callee.(*Function)
+	if !ok {
+		c.errorf(tok, "not a function: %s", callee)
+		return Any
+	}
+	if len(args) != len(fn.Params) {
+		c.errorf(tok, "wrong number of arguments: want=%d, got=%d", len(fn.Params), len(args))
+		return fn.Return
+	}
+	for i, arg := range args {
+		if !Compatible(fn.Params[i], arg) {
+			c.errorf(tok, "cannot use %s as %s in argument %d", arg, fn.Params[i], i+1)
+		}
+	}
+	return fn.Return
+}
+
+func (c *Checker) index(e *ast.IndexExpression) Type {
+	left := c.expression(e.Left)
+	index := c.expression(e.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !Compatible(Int, index) {
+			c.errorf(e.Token, "array index must be int, got %s", index)
+		}
+		return left.Elem
+	case *Hash:
+		if c.key(e.Token, index, "hash key") && left.Declared && !Compatible(left.Key, index) {
+			c.errorf(e.Token, "cannot use %s as %s hash key", index, left.Key)
+		}
+		return left.Value
+	case *Tuple:
+		if !Compatible(Int, index) {
+			c.errorf(e.Token, "tuple index must be int, got %s", index)
+		}
+		return tupleIndex(left, e.Index)
+	}
+	if left == String {
+		if !Compatible(Int, index) {
+			c.errorf(e.Token, "string index must be int, got %s", index)
+		}
+		return String
+	}
+	return Any
+}
+
+// destructure binds the names of `let (a, b) = ...` to the element types of
+// a tuple or array.
+func (c *Checker) destructure(s *ast.LetStatement) {
+	for _, name := range s.Names {
+		c.declare(name)
+	}
+	got := c.expression(s.Value)
+	elems := make([]Type, len(s.Names))
+	switch t := got.(type) {
+	case *Tuple:
+		if len(t.Elems) != len(s.Names) {
+			c.errorf(s.Token, "cannot unpack %s into %d values", got, len(s.Names))
+			break
+		}
+		copy(elems, t.Elems)
+	case *Array:
+		for i := range elems {
+			elems[i] = t.Elem
+		}
+	default:
+		if got != Any {
+			c.errorf(s.Token, "cannot unpack %s into %d values", got, len(s.Names))
+		}
+	}
+	for i, name := range s.Names {
+		if elems[i] == nil {
+			elems[i] = Any
+		}
+		c.scope.store[name.Value] = elems[i]
+		if s.Token.Type == token.CONST {
+			c.scope.consts[name.Value] = true
+		}
+	}
+}
+
+// declare reports a statement binding name again in the scope where it is a
+// const. Both engines refuse that too, but only once they reach it.
+func (c *Checker) declare(name *ast.Identifier) {
+	if c.scope.consts[name.Value] {
+		c.errorf(name.Token, "cannot redeclare const %s", name.Value)
+	}
+}
+
+// clause binds the variables of a comprehension clause in the current scope.
+func (c *Checker) clause(cl *ast.ComprehensionClause) {
+	elems := elements(c.expression(cl.Iterable), len(cl.Variables))
+	for i, v := range cl.Variables {
+		c.scope.store[v.Value] = elems[i]
+	}
+	if cl.Condition != nil {
+		c.expression(cl.Condition)
+	}
+}
+
+// elements returns the types bound by iterating over t with n variables.
+// With two variables arrays yield index and element and hashes key and value.
+func elements(t Type, n int) []Type {
+	var key, value Type = Any, Any
+	switch t := t.(type) {
+	case *Array:
+		key, value = Int, t.Elem
+	case *Set:
+		key = t.Elem
+	case *Hash:
+		key, value = t.Key, t.Value
+	}
+	if n == 1 {
+		if arr, ok := t.(*Array); ok {
+			key = arr.Elem
+		} else if t == String {
+			key = String
+		} else if t == Range {
+			key = Int
+		}
+	}
+	return []Type{key, value}
+}
@@ -0,0 +1,126 @@
+package typecheck
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func check(t *testing.T, input string) []*Error {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Check(program)
+}
+
+func TestWellTypedPrograms(t *testing.T) {
+	tests := []string{
+		`let x: int = 5; x + 1`,
+		`let s: string = "a"; s + "b"`,
+		`let f = fn(a: int, b: string): bool { len(b) > a }; f(1, "two")`,
+		`let f = fn(a, b) { a + b }; f(1, 2); f("a", "b")`,
+		`let fib = fn(n: int): int { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(10)`,
+		`let sign = fn(n: int): int { if (n < 0) { return -1 } else { return 1 } }`,
+		`let a: [int] = [1, 2, 3]; a[0] + a[-1]`,
+		`let h: {string: int} = {"a": 1}; h["a"] * 2`,
+		`let s: #{int} = #{1, 2}`,
+		`let g: fn(int): int = fn(x: int): int { x * 2 }; g(2)`,
+		`let apply = fn(f: fn(int): int, x: int): int { f(x) }; apply(fn(x) { x }, 1)`,
+		`let xs: [any] = [1, "two"]`,
+		`[x * 2 for x in 0..10 if x > 3]`,
+		`{k: v + 1 for k, v in {"a": 1}}`,
+		`for (c in "abc") { puts(c + "!") }`,
+		`1 |> fn(a: int, b: int): int { a + b }(2)`,
+		`"abc"[1:] + "d"`,
+		`1 == "one"`,
+		`enum Shape { Circle(r), Empty }; let s: Shape = Circle(1); let t: [Shape] = [s, Empty]`,
+		`2 in [1, 2] == "a" in {"a": 1}`,
+		`let f = fn(s) { s in "monkey" }; f("key")`,
+		`let (a, b) = (1, "b"); a + 1; b + "c"`,
+		`let (a, b) = [1, 2]; a + b`,
+		`let h = {1: "a"}; puts(h["x"])`,
+		`"x" in [1, 2]`,
+		`"x" in {1: 2}`,
+		`"a" in #{1, 2}`,
+		`let h = {[1]: "a"}; h[(1, "b")]`,
+		`const a = 1; if (true) { let a = "a" }; fn(a) { const a = 2 }`,
+	}
+
+	for _, tt := range tests {
+		if errs := check(t, tt); len(errs) != 0 {
+			t.Errorf("unexpected type errors for %q: %v", tt, errs)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + "a"`, `1:3: type mismatch: int + string`},
+		{`true + false`, `1:6: unknown operator: bool + bool`},
+		{`"a" - "b"`, `1:5: unknown operator: string - string`},
+		{`-"a"`, `1:1: unknown operator: -string`},
+		{`let x: int = "five"`, `1:5: cannot use string as int in let x`},
+		{`let x: integer = 5`, `1:8: unknown type integer`},
+		{"let f = fn(a: int) { a };\nf(\"a\")", `2:2: cannot use string as int in argument 1`},
+		{`let f = fn(a: int, b: int) { a }; f(1)`, `1:36: wrong number of arguments: want=2, got=1`},
+		{`let x = 5; x(1)`, `1:13: not a function: int`},
+		{`fn(): int { "a" }`, `1:1: cannot return string from a function returning int`},
+		{`fn(): int { return "a"; }`, `1:13: cannot return string from a function returning int`},
+		{`fn(a: string) { a + 1 }`, `1:19: type mismatch: string + int`},
+		{`[1, 2]["a"]`, `1:7: array index must be int, got string`},
+		{`let (a, b) = (1, "b"); a + b`, `1:26: type mismatch: int + string`},
+		{`let (a, b) = 5`, `1:1: cannot unpack int into 2 values`},
+		{`let h: {string: int} = {"a": 1}; h[1]`, `1:35: cannot use int as string hash key`},
+		{`let h = {"a": 1}; h[fn() {}]`, `1:20: unusable as hash key: fn(): any`},
+		{`let h = {"a": 1}; h[[0..1]]`, `1:20: unusable as hash key: [range]`},
+		{`[1][1:"a"]`, `1:4: slice bounds must be int, got string`},
+		{`"a".."b"`, `1:4: unsupported types for range: string..string`},
+		{`[x + "!" for x in 0..3]`, `1:4: type mismatch: int + string`},
+		{`let f: fn(int): int = fn(s: string): int { 1 }`, `1:5: cannot use fn(string): int as fn(int): int in let f`},
+		{"if (true) {\n  let n: int = 1;\n  n + \"x\"\n}", `3:5: type mismatch: int + string`},
+		{`"a" |> fn(a: int) { a }`, `1:5: cannot use string as int in argument 1`},
+		{`let xs: [int] = [1, 2]; "a" in xs`, `1:29: cannot look for string in [int]`},
+		{`let s: #{int} = #{1}; "a" in s`, `1:27: cannot look for string in #{int}`},
+		{`fn(h: {int: int}) { "a" in h }`, `1:25: cannot look for string in {int: int}`},
+		{`fn() {} in #{1}`, `1:9: unusable as set element: fn(): any`},
+		{`1 in "abc"`, `1:3: cannot look for int in string`},
+		{`1 in 5`, `1:3: unknown operator: int in int`},
+		{`enum Shape { Empty }; let s: Shape = 1`, `1:27: cannot use int as Shape in let s`},
+		{`enum Shape { Empty }; Empty + 1`, `1:29: type mismatch: Shape + int`},
+		{`const a = 1; puts("x"); let a = 2`, `1:29: cannot redeclare const a`},
+		{`const (a, b) = (1, 2); let (c, b) = (3, 4)`, `1:32: cannot redeclare const b`},
+		{`const Red = 1; enum Color { Red }`, `1:29: cannot redeclare const Red`},
+		{`if (true) { const a = 1; const a = 2 }`, `1:32: cannot redeclare const a`},
+	}
+
+	for _, tt := range tests {
+		errs := check(t, tt.input)
+		if len(errs) != 1 {
+			t.Errorf("wrong number of errors for %q. want=1, got=%d (%v)", tt.input, len(errs), errs)
+			continue
+		}
+		if errs[0].Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errs[0].Error())
+		}
+	}
+}
+
+func TestCheckerKeepsGlobals(t *testing.T) {
+	c := New()
+	p := parser.New(lexer.New(`let x: int = 1;`))
+	if errs := c.Check(p.ParseProgram()); len(errs) != 0 {
+		t.Fatalf("unexpected type errors: %v", errs)
+	}
+
+	p = parser.New(lexer.New(`x + "a"`))
+	errs := c.Check(p.ParseProgram())
+	if len(errs) != 1 || errs[0].Message != "type mismatch: int + string" {
+		t.Fatalf("wrong errors. got=%v", errs)
+	}
+}
@@ -0,0 +1,246 @@
+package typecheck
+
+import (
+	"fmt"
+	"monkey/ast"
+	"strings"
+)
+
+// Type is the static type of a Monkey expression.
+type Type interface {
+	String() string
+}
+
+// Basic is a type without parameters, such as int or string.
+type Basic struct {
+	Name string
+}
+
+func (b *Basic) String() string { return b.Name }
+
+var (
+	Int     = &Basic{Name: "int"}
+	String  = &Basic{Name: "string"}
+	Bool    = &Basic{Name: "bool"}
+	Null    = &Basic{Name: "null"}
+	Range   = &Basic{Name: "range"}
+	Channel = &Basic{Name: "channel"}
+	// Any is the type of everything the checker knows nothing about. It is
+	// compatible with every other type, so unannotated code is never rejected.
+	Any = &Basic{Name: "any"}
+)
+
+var basics = map[string]*Basic{
+	"int":     Int,
+	"string":  String,
+	"bool":    Bool,
+	"null":    Null,
+	"range":   Range,
+	"channel": Channel,
+	"any":     Any,
+}
+
+// Enum is the type of the variants of one enum declaration.
+type Enum struct {
+	Name string
+	Impl bool // set by an impl for the enum, which may give it any operator
+}
+
+func (e *Enum) String() string { return e.Name }
+
+// Declared is set on collection types written in an annotation. The checker
+// holds only those to their element and key types, since a mismatch with an
+// inferred one, such as a lookup that finds nothing, still runs fine.
+type Array struct {
+	Elem     Type
+	Declared bool
+}
+
+func (a *Array) String() string { return "[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key      Type
+	Value    Type
+	Declared bool
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Set struct {
+	Elem     Type
+	Declared bool
+}
+
+func (s *Set) String() string { return "#{" + s.Elem.String() + "}" }
+
+type Tuple struct {
+	Elems []Type
+}
+
+func (t *Tuple) String() string {
+	var elems []string
+	for _, e := range t.Elems {
+		elems = append(elems, e.String())
+	}
+	if len(elems) == 1 {
+		return "(" + elems[0] + ",)"
+	}
+	return "(" + strings.Join(elems, ", ") + ")"
+}
+
+type Function struct {
+	Params []Type
+	Return Type
+}
+
+func (f *Function) String() string {
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + "): " + f.Return.String()
+}
+
+// FromAnnotation turns a written type into a Type, looking names that are not
+// built in up in enums. A nil annotation is any.
+func FromAnnotation(a *ast.TypeAnnotation, enums map[string]*Enum) (Type, error) {
+	if a == nil {
+		return Any, nil
+	}
+	var params []Type
+	for _, p := range a.Parameters {
+		t, err := FromAnnotation(p, enums)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, t)
+	}
+	switch a.Name {
+	case "array":
+		return &Array{Elem: params[0], Declared: true}, nil
+	case "hash":
+		return &Hash{Key: params[0], Value: params[1], Declared: true}, nil
+	case "set":
+		return &Set{Elem: params[0], Declared: true}, nil
+	case "fn":
+		ret, err := FromAnnotation(a.Return, enums)
+		if err != nil {
+			return nil, err
+		}
+		return &Function{Params: params, Return: ret}, nil
+	}
+	if b, ok := basics[a.Name]; ok {
+		return b, nil
+	}
+	if e, ok := enums[a.Name]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", a.Name)
+}
+
+// Compatible reports whether a value of type got may be used where want is
+// expected.
+func Compatible(want, got Type) bool {
+	if want == Any || got == Any {
+		return true
+	}
+	switch want := want.(type) {
+	case *Basic, *Enum:
+		return want == got
+	case *Array:
+		got, ok := got.(*Array)
+		return ok && Compatible(want.Elem, got.Elem)
+	case *Set:
+		got, ok := got.(*Set)
+		return ok && Compatible(want.Elem, got.Elem)
+	case *Hash:
+		got, ok := got.(*Hash)
+		return ok && Compatible(want.Key, got.Key) && Compatible(want.Value, got.Value)
+	case *Tuple:
+		got, ok := got.(*Tuple)
+		if !ok || len(want.Elems) != len(got.Elems) {
+			return false
+		}
+		for i := range want.Elems {
+			if !Compatible(want.Elems[i], got.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *Function:
+		got, ok := got.(*Function)
+		if !ok || len(want.Params) != len(got.Params) {
+			return false
+		}
+		for i := range want.Params {
+			if !Compatible(got.Params[i], want.Params[i]) {
+				return false
+			}
+		}
+		return Compatible(want.Return, got.Return)
+	}
+	return false
+}
+
+// variantType is the type a variant's name is bound to: the enum itself, or a
+// constructor function when the variant has fields.
+func variantType(enum *Enum, v *ast.EnumVariant) Type {
+	if len(v.Fields) == 0 {
+		return enum
+	}
+	fn := &Function{Return: enum}
+	for range v.Fields {
+		fn.Params = append(fn.Params, Any)
+	}
+	return fn
+}
+
+// tupleIndex returns the element of a tuple selected by an integer literal,
+// or any when the index is not a constant in range.
+func tupleIndex(t *Tuple, index ast.Expression) Type {
+	lit, ok := index.(*ast.IntegerLiteral)
+	if !ok || lit.Value >= int64(len(t.Elems)) {
+		return Any
+	}
+	return t.Elems[lit.Value]
+}
+
+// implemented reports whether t is an enum with an impl. Its operators run
+// user code, so the checkers accept them whatever the operands.
+func implemented(t Type) bool {
+	e, ok := t.(*Enum)
+	return ok && e.Impl
+}
+
+// unhashable reports whether values of type t can never be hash keys or set
+// elements, so that using one as such fails at runtime.
+func unhashable(t Type) bool {
+	switch t := t.(type) {
+	case *Function, *Set:
+		return true
+	case *Basic:
+		return t == Range || t == Channel || t == Null
+	case *Array:
+		return unhashable(t.Elem)
+	case *Hash:
+		return unhashable(t.Key) || unhashable(t.Value)
+	case *Tuple:
+		for _, e := range t.Elems {
+			if unhashable(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// join returns the type shared by a and b, or any if they differ.
+func join(a, b Type) Type {
+	if a == nil {
+		return b
+	}
+	if a.String() == b.String() {
+		return a
+	}
+	return Any
+}
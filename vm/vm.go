@@ -5,41 +5,111 @@ import (
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"sync"
 )
 
 const stackSize = 2048
 const GlobalsSize = 65536
+const MaxFrames = 1024
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
+
+// Globals is the global store of a program. Functions started with `spawn`
+// run on their own VM but share the store of the VM that spawned them, as do
+// the VMs a REPL runs its lines on, so the store carries the lock guarding
+// its slots; unrelated VMs never wait for each other. Each read and write of
+// a global is atomic. Values other than arrays are never changed in place,
+// so they need no further synchronisation. Arrays changed by the `!`
+// builtins are not locked: a program must not change an array while another
+// goroutine uses it, and should hand it over through a channel instead.
+type Globals struct {
+	mu    sync.RWMutex
+	slots []object.Object
+}
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+func NewGlobals() *Globals {
+	return &Globals{slots: make([]object.Object, GlobalsSize)}
+}
+
+// Set defines a global before the program runs, such as a builtin the host
+// provides.
+func (g *Globals) Set(index int, obj object.Object) {
+	g.mu.Lock()
+	g.slots[index] = obj
+	g.mu.Unlock()
+}
+
+func (g *Globals) get(index int) object.Object {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.slots[index]
+}
 
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
 
 	stack []object.Object
 	sp    int // Always points to the next value. Top of the stack is stack[sp - 1]
 
-	globals []object.Object
+	globals *Globals
+
+	frames      []*Frame
+	framesIndex int
+
+	yielded object.Object // set by OpYield when a generator suspends
+
+	methods *object.Methods // filled by OpImpl, shared with forked VMs
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
 	return &VM{
-		constants:    bytecode.Constants,
-		instructions: bytecode.Instructions,
-		stack:        make([]object.Object, stackSize),
-		sp:           0,
-		globals:      make([]object.Object, GlobalsSize),
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, stackSize),
+		sp:          0,
+		globals:     NewGlobals(),
+		frames:      frames,
+		framesIndex: 1,
+		methods:     object.NewMethods(),
 	}
 }
 
-func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+func NewWithGlobalStore(bytecode *compiler.Bytecode, s *Globals) *VM {
 	vm := New(bytecode)
 	vm.globals = s
 	return vm
 }
 
+// NewWithState is like NewWithGlobalStore but also keeps the impls declared
+// by earlier runs, as the REPL does between lines.
+func NewWithState(bytecode *compiler.Bytecode, s *Globals, methods *object.Methods) *VM {
+	vm := NewWithGlobalStore(bytecode, s)
+	vm.methods = methods
+	return vm
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -52,13 +122,56 @@ func (vm *VM) LastPoppedStack() object.Object {
 }
 
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+	return vm.run(0)
+}
+
+// Call invokes fn with args on top of whatever the VM is currently running,
+// e.g. a callback queued by the program after Run has returned.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	depth := vm.framesIndex
+	sp := vm.sp
+
+	err := vm.push(fn)
+	if err == nil {
+		for _, a := range args {
+			if err = vm.push(a); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	if err == nil {
+		err = vm.run(depth)
+	}
+	if err != nil {
+		// unwind whatever the failed call left behind
+		vm.framesIndex = depth
+		vm.sp = sp
+		return nil, err
+	}
+	return vm.pop(), nil
+}
+
+// run executes instructions until the frame count drops to depth, the
+// outermost frame runs out of instructions, or a generator yields.
+func (vm *VM) run(depth int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.framesIndex > depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
 
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
@@ -97,15 +210,15 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpJump:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip = pos - 1
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip += 2
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				ip = pos - 1
+				vm.currentFrame().ip = pos - 1
 			}
 		case code.OpNull:
 			err := vm.push(Null)
@@ -113,22 +226,22 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
-			vm.globals[globalIndex] = vm.pop()
+			vm.globals.Set(int(globalIndex), vm.pop())
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.push(vm.globals.get(int(globalIndex)))
 			if err != nil {
 				return err
 			}
 		case code.OpArray:
-			numElements := int(code.ReadUint16(vm.instructions[ip+1:]))
+			numElements := int(code.ReadUint16(ins[ip+1:]))
 
-			ip += 2
+			vm.currentFrame().ip += 2
 
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
@@ -137,10 +250,21 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTuple:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp).(*object.Array)
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(&object.Tuple{Elements: array.Elements})
+			if err != nil {
+				return err
+			}
 		case code.OpHash:
-			numElements := int(code.ReadUint16(vm.instructions[ip+1:]))
+			numElements := int(code.ReadUint16(ins[ip+1:]))
 
-			ip += 2
+			vm.currentFrame().ip += 2
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -152,6 +276,64 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSet:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+
+			vm.currentFrame().ip += 2
+
+			set, err := vm.buildSet(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(set)
+			if err != nil {
+				return err
+			}
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			result, err := object.Slice(left, start, end)
+			if err != nil {
+				return err
+			}
+			err = vm.push(result)
+			if err != nil {
+				return err
+			}
+		case code.OpRange:
+			end := vm.pop()
+			start := vm.pop()
+
+			err := vm.executeRange(start, end)
+			if err != nil {
+				return err
+			}
+		case code.OpIn:
+			container := vm.pop()
+			element := vm.pop()
+
+			found, err := object.Contains(container, element)
+			if err != nil {
+				return err
+			}
+			err = vm.push(nativeBoolToBooleanObject(found))
+			if err != nil {
+				return err
+			}
+		case code.OpImpl:
+			tagIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			tag := vm.constants[tagIndex].(*object.String)
+			methods := vm.pop().(*object.Hash)
+			err := vm.methods.Impl(tag.Value, methods)
+			if err != nil {
+				return err
+			}
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
@@ -160,11 +342,355 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+		case code.OpYield:
+			vm.yielded = vm.pop()
+
+			// the value of the `yield` expression once the generator resumes
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+			return nil
+		case code.OpIter:
+			iterable := vm.pop()
+
+			it, ok := iterable.(object.Iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+			err := vm.push(it.Iterator())
+			if err != nil {
+				return err
+			}
+		case code.OpIterPairs:
+			iterable := vm.pop()
+
+			pairs, ok := object.Pairs(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over key/value pairs of %s", iterable.Type())
+			}
+			err := vm.push(pairs)
+			if err != nil {
+				return err
+			}
+		case code.OpUnpack:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			err := vm.executeUnpack(n)
+			if err != nil {
+				return err
+			}
+		case code.OpAppend:
+			value := vm.pop()
+
+			array := vm.stack[vm.sp-2].(*object.Array)
+			array.Elements = append(array.Elements, value)
+		case code.OpInsert:
+			value := vm.pop()
+			key := vm.pop()
+
+			hash := vm.stack[vm.sp-2].(*object.Hash)
+			if !hash.Put(key, value) {
+				return object.KeyError("hash key", key)
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iterator := vm.StackTop().(object.Iterator)
+			value, ok := iterator.Next()
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			if err, isErr := value.(*object.Error); isErr {
+				return fmt.Errorf("%s", err.Message)
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpSpawn:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			err := vm.executeSpawn(numArgs)
+			if err != nil {
+				return err
+			}
+		case code.OpSelect:
+			numCases := int(code.ReadUint8(ins[ip+1:]))
+			hasDefault := code.ReadUint8(ins[ip+2:]) == 1
+			vm.currentFrame().ip += 2
+
+			chosen, err := vm.executeSelect(numCases, hasDefault)
+			if err != nil {
+				return err
+			}
+			// skip to the jump table entry of the chosen case
+			vm.currentFrame().ip += chosen * 3
 		}
 	}
 	return nil
 }
 
+// fork returns a VM without frames that shares constants and globals with
+// vm. It runs a single call on a stack of its own.
+func (vm *VM) fork() *VM {
+	return &VM{
+		constants: vm.constants,
+		stack:     make([]object.Object, stackSize),
+		globals:   vm.globals,
+		frames:    make([]*Frame, MaxFrames),
+		methods:   vm.methods,
+	}
+}
+
+// executeSpawn starts the called function on a forked VM in its own
+// goroutine and pushes a channel that receives the result.
+func (vm *VM) executeSpawn(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee.(type) {
+	case *object.Closure, *object.Builtin:
+	default:
+		return fmt.Errorf("cannot spawn %s", callee.Type())
+	}
+
+	child := vm.fork()
+	for i := vm.sp - 1 - numArgs; i < vm.sp; i++ {
+		_ = child.push(vm.stack[i])
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	result := object.NewChannel(1)
+	go func() {
+		var value object.Object
+		err := child.executeCall(numArgs)
+		if err == nil {
+			err = child.run(0)
+		}
+		if err != nil {
+			value = &object.Error{Message: err.Error()}
+		} else {
+			value = child.pop()
+		}
+		_ = result.Send(value)
+		_ = result.Close()
+	}()
+	return vm.push(result)
+}
+
+func (vm *VM) executeSelect(numCases int, hasDefault bool) (int, error) {
+	start := vm.sp - numCases*3
+	cases := make([]object.SelectCase, numCases)
+	for i := range cases {
+		channel := vm.stack[start+i*3]
+		ch, ok := channel.(*object.Channel)
+		if !ok {
+			return 0, fmt.Errorf("select case is not a channel: %s", channel.Type())
+		}
+		cases[i].Channel = ch
+		if vm.stack[start+i*3+2] == True {
+			cases[i].Value = vm.stack[start+i*3+1]
+		}
+	}
+	vm.sp = start
+
+	chosen, received, err := object.Select(cases, hasDefault)
+	if err != nil {
+		return 0, err
+	}
+	if received == nil {
+		received = Null
+	}
+	return chosen, vm.push(received)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		if callee.Fn.IsGenerator {
+			return vm.callGenerator(callee, numArgs)
+		}
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Call(runtime{vm: vm}, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+// runtime is the object.Runtime of builtins called by vm.
+type runtime struct {
+	vm *VM
+}
+
+func (r runtime) Call(fn object.Object, args ...object.Object) object.Object {
+	result, err := r.vm.Call(fn, args...)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+func (r runtime) Method(obj object.Object, name string) (object.Object, bool) {
+	return r.vm.methods.Lookup(obj, name)
+}
+
+// callGenerator replaces the call with a generator object. The body gets a
+// VM of its own, sharing constants and globals with this one, whose frames
+// and stack stay put between yields.
+func (vm *VM) callGenerator(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	body := vm.fork()
+	for i := vm.sp - 1 - numArgs; i < vm.sp; i++ {
+		_ = body.push(vm.stack[i])
+	}
+	err := body.callClosure(cl, numArgs)
+	if err != nil {
+		return err
+	}
+
+	vm.sp = vm.sp - numArgs - 1
+	return vm.push(&object.Generator{Resume: body.resume})
+}
+
+func (vm *VM) resume() (object.Object, bool) {
+	if vm.framesIndex == 0 {
+		return nil, false
+	}
+	err := vm.run(0)
+	if err != nil {
+		vm.framesIndex = 0
+		return &object.Error{Message: err.Error()}, true
+	}
+	value := vm.yielded
+	vm.yielded = nil
+	return value, value != nil
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
 func isTruthy(condition object.Object) bool {
 	switch obj := condition.(type) {
 	case *object.Boolean:
@@ -189,6 +715,9 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	if leftType == object.STRING_OBJ && rightType == object.STRING_OBJ {
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
+	if ok, err := vm.executeOperatorMethod(op, left, right); ok {
+		return err
+	}
 	return fmt.Errorf("unsupported types for binary operation %s %s", leftType, rightType)
 }
 
@@ -244,17 +773,64 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	if ok, err := vm.executeOperatorMethod(op, left, right); ok {
+		return err
+	}
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	}
+
+	// what is left is ordering, which only integers and strings support
 	leftType := left.Type()
 	rightType := right.Type()
 
 	if leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ {
 		return vm.executeIntegerComparisonOperation(op, left, right)
-	} else if leftType == object.BOOLEAN_OBJ && rightType == object.BOOLEAN_OBJ {
-		return vm.executeBinaryComparisonOperation(op, left, right)
+	} else if leftType == object.STRING_OBJ && rightType == object.STRING_OBJ {
+		return vm.executeStringComparisonOperation(op, left, right)
 	}
 	return fmt.Errorf("unsupported types for comparision operation %s %s", leftType, rightType)
 }
 
+// operatorMethods names the impl method each operator dispatches to.
+var operatorMethods = map[code.Opcode]string{
+	code.OpAdd:      "+",
+	code.OpSub:      "-",
+	code.OpMul:      "*",
+	code.OpDiv:      "/",
+	code.OpEqual:    "==",
+	code.OpNotEqual: "==",
+}
+
+// executeOperatorMethod calls the impl of op for the type of left or, failing
+// that, of right, and pushes the result. It reports false when neither type
+// implements op.
+func (vm *VM) executeOperatorMethod(op code.Opcode, left, right object.Object) (bool, error) {
+	name, ok := operatorMethods[op]
+	if !ok {
+		return false, nil
+	}
+	fn, ok := vm.methods.Lookup(left, name)
+	if !ok {
+		fn, ok = vm.methods.Lookup(right, name)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	result, err := vm.Call(fn, left, right)
+	if err != nil {
+		return true, err
+	}
+	if op == code.OpNotEqual {
+		result = nativeBoolToBooleanObject(!isTruthy(result))
+	}
+	return true, vm.push(result)
+}
+
 func (vm *VM) executeIntegerComparisonOperation(op code.Opcode, left object.Object, right object.Object) error {
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
@@ -262,10 +838,6 @@ func (vm *VM) executeIntegerComparisonOperation(op code.Opcode, left object.Obje
 	switch op {
 	case code.OpGreaterThan:
 		result = leftValue > rightValue
-	case code.OpEqual:
-		result = leftValue == rightValue
-	case code.OpNotEqual:
-		result = leftValue != rightValue
 	default:
 		return fmt.Errorf("unknown integer operation %d", op)
 	}
@@ -276,23 +848,17 @@ func (vm *VM) executeIntegerComparisonOperation(op code.Opcode, left object.Obje
 	return nil
 }
 
-func (vm *VM) executeBinaryComparisonOperation(op code.Opcode, left object.Object, right object.Object) error {
-	leftValue := left.(*object.Boolean).Value
-	rightValue := right.(*object.Boolean).Value
+func (vm *VM) executeStringComparisonOperation(op code.Opcode, left object.Object, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
 	var result bool
 	switch op {
-	case code.OpEqual:
-		result = leftValue == rightValue
-	case code.OpNotEqual:
-		result = leftValue != rightValue
+	case code.OpGreaterThan:
+		result = leftValue > rightValue
 	default:
-		return fmt.Errorf("unknown boolean operation %d", op)
-	}
-	err := vm.push(nativeBoolToBooleanObject(result))
-	if err != nil {
-		return err
+		return fmt.Errorf("unknown string operation %d", op)
 	}
-	return nil
+	return vm.push(nativeBoolToBooleanObject(result))
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -329,29 +895,50 @@ func (vm *VM) buildArray(startIndex int, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex int, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
+		if !hash.Put(key, value) {
+			return nil, object.KeyError("hash key", key)
+		}
+	}
+	return hash, nil
+}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+func (vm *VM) buildSet(startIndex int, endIndex int) (object.Object, error) {
+	set := object.NewSet()
+
+	for i := startIndex; i < endIndex; i++ {
+		if !set.Add(vm.stack[i]) {
+			return nil, object.KeyError("set element", vm.stack[i])
 		}
-		hashedPairs[hashKey.HashKey()] = pair
 	}
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return set, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.VARIANT_OBJ:
+		val, err := left.(*object.Variant).Index(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(val)
+	case left.Type() == object.TUPLE_OBJ:
+		val, err := left.(*object.Tuple).Index(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(val)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
@@ -362,25 +949,67 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	i := index.(*object.Integer).Value
 	maxPossibleIndex := int64(len(arrayObject.Elements) - 1)
 
+	if i < 0 {
+		i += maxPossibleIndex + 1
+	}
 	if i < 0 || i > maxPossibleIndex {
 		return vm.push(Null)
 	}
 	return vm.push(arrayObject.Elements[i])
 }
 
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	runes := []rune(str.(*object.String).Value)
+	i := index.(*object.Integer).Value
+	maxPossibleIndex := int64(len(runes) - 1)
+
+	if i < 0 {
+		i += maxPossibleIndex + 1
+	}
+	if i < 0 || i > maxPossibleIndex {
+		return vm.push(Null)
+	}
+	return vm.push(&object.String{Value: string(runes[i])})
+}
+
+func (vm *VM) executeUnpack(n int) error {
+	elements, err := object.Unpack(vm.pop(), n)
+	if err != nil {
+		return err
+	}
+	for _, el := range elements {
+		err := vm.push(el)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vm *VM) executeRange(start, end object.Object) error {
+	startValue, ok := start.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("unsupported types for range: %s..%s", start.Type(), end.Type())
+	}
+	endValue, ok := end.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("unsupported types for range: %s..%s", start.Type(), end.Type())
+	}
+	return vm.push(&object.Range{Start: startValue.Value, End: endValue.Value})
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	if err := object.KeyError("hash key", index); err != nil {
+		return err
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(index)
 	if !ok {
 		return vm.push(Null)
 	}
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -59,6 +59,20 @@ func TestBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`#{1, 2} == #{2, 1}`, true},
+		{`"ab" == "a" + "b"`, true},
+		{`1..3 == 1..3`, true},
+		{`1 == "1"`, false},
+		{`[1] != {"a": 1}`, true},
+		{`true == 1`, false},
+		{`new("P", {"x": 1}) == {"x": 1}`, false},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 		{"!true", false},
 		{"!false", true},
 		{"!5", false},
@@ -100,6 +114,21 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"apple" < "banana"`, true},
+		{`"apple" > "banana"`, false},
+		{`"b" > "abc"`, true},
+		{`"" < "a"`, true},
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[-1]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`let s = "monkey"; s[len(s) - 1]`, "y"},
+		{`"monkey"[6]`, Null},
+		{`""[0]`, Null},
+		{`len("héllo")`, 5},
+		{`let g = fn*(s) { for (c in s) { yield c + c } }; let it = g("ab"); next(it) + next(it)`, "aabb"},
 	}
 	runVmTests(t, tests)
 }
@@ -128,6 +157,266 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSlicesAndRanges(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, 2, 3, 4][1:3]`, []int{2, 3}},
+		{`[1, 2, 3, 4][:-1]`, []int{1, 2, 3}},
+		{`[1, 2, 3, 4][-2:]`, []int{3, 4}},
+		{`[1, 2, 3, 4][:]`, []int{1, 2, 3, 4}},
+		{`[1, 2, 3, 4][3:1]`, []int{}},
+		{`[1, 2, 3, 4][-10:10]`, []int{1, 2, 3, 4}},
+		{`let a = [1, 2, 3]; let i = 1; a[i:i + 1]`, []int{2}},
+		{`"hello"[2:]`, "llo"},
+		{`"héllo"[1:3]`, "él"},
+		{`"hello"[:-3]`, "he"},
+		{`1..10`, &object.Range{Start: 1, End: 10}},
+		{`1 + 1..2 * 5`, &object.Range{Start: 2, End: 10}},
+		{`len(0..10)`, 10},
+		{`len(5..1)`, 0},
+		{`let g = fn*() { for (i in 3..6) { yield i } }; let it = g(); next(it); next(it)`, 4},
+	}
+	runVmTests(t, tests)
+}
+
+func TestEnums(t *testing.T) {
+	enum := `enum Shape { Circle(r), Rect(w, h), Empty };`
+	tests := []vmTestCase{
+		{enum + `Circle(2) == Circle(2)`, true},
+		{enum + `Circle(2) == Circle(3)`, false},
+		{enum + `Circle(2) != Rect(2, 2)`, true},
+		{enum + `Empty == Empty`, true},
+		{enum + `let area = fn(s) { if (tag(s) == "Rect") { s["w"] * s["h"] } else { 0 } }; area(Rect(3, 4)) + area(Circle(1))`, 12},
+		{enum + `Rect(3, 4)[1]`, 4},
+		{enum + `Rect(3, 4)[-2]`, 3},
+		{enum + `Rect(3, 4)[2]`, Null},
+		{enum + `let h = {Circle(1): "one", Empty: "none"}; h[Circle(1)] + h[Empty]`, "onenone"},
+		{enum + `Circle([1]) in [Circle([1])]`, true},
+		{enum + `Circle(1) in [Empty, Circle(1)]`, true},
+		{enum + `len(#{Empty, Empty, Circle(1)})`, 2},
+		{`let f = fn() { enum State { On, Off } [On, Off] }; f()[0] == f()[0]`, true},
+		{enum + `if (Circle(0)) { 1 } else { 2 }`, 1},
+		{enum + `Rect(1)`, &object.Error{Message: "wrong number of arguments to `Rect`. got=1, want=2"}},
+	}
+	runVmTests(t, tests)
+
+	errors := map[string]string{
+		enum + `Circle(1)["w"]`:    "Circle has no field w",
+		enum + `Circle(1)[true]`:   "unusable as variant index: BOOLEAN",
+		enum + `Circle(1) > Empty`: "unsupported types for comparision operation VARIANT VARIANT",
+	}
+	for input, expected := range errors {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", input, expected, err)
+		}
+	}
+}
+
+func TestTuples(t *testing.T) {
+	tests := []vmTestCase{
+		{`(1, 2)[1]`, 2},
+		{`(1, 2)[-2]`, 1},
+		{`(1, 2)[2]`, Null},
+		{`len((1, "a", true))`, 3},
+		{`(1, "a") == (1, "a")`, true},
+		{`(1, "a") == (1, "b")`, false},
+		{`(1, (2, 3)) != (1, (2, 3))`, false},
+		{`let h = {(1, 2): "a", (2, 1): "b"}; h[(2, 1)]`, "b"},
+		{`len(#{(1, 2), (1, 2), (2, 1)})`, 2},
+		{`(1, 2) in [(0, 0), (1, 2)]`, true},
+		{`"a" in (1, "a")`, true},
+		{`let divmod = fn(a, b) { return a / b, a - (a / b) * b }; let (q, r) = divmod(17, 5); q * 10 + r`, 32},
+		{`let f = fn() { let (a, b) = [1, 2]; a + b }; f()`, 3},
+		{`let x = 1; let (x, y) = (x + 1, x); x * 10 + y`, 21},
+	}
+	runVmTests(t, tests)
+
+	errors := map[string]string{
+		`let (a, b) = (1, 2, 3)`: "cannot unpack (1, 2, 3) into 2 values",
+		`let (a, b) = 1`:         "cannot unpack 1 into 2 values",
+		`(1, 2)["a"]`:            "unusable as tuple index: STRING",
+		`(1, 2) > (0, 1)`:        "unsupported types for comparision operation TUPLE TUPLE",
+	}
+	for input, expected := range errors {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", input, expected, err)
+		}
+	}
+}
+
+func TestImpls(t *testing.T) {
+	point := `impl Point {
+		"+": fn(a, b) { new("Point", {"x": a["x"] + b["x"]}) },
+		"==": fn(a, b) { a["x"] == b["x"] },
+		"to_string": fn(p) { "Point(" + to_string(p["x"]) + ")" },
+		"len": fn(p) { p["x"] }
+	};
+	let p = fn(x) { new("Point", {"x": x}) };`
+	tests := []vmTestCase{
+		{point + `(p(1) + p(2))["x"]`, 3},
+		{point + `p(1) == p(1)`, true},
+		{point + `p(1) == p(2)`, false},
+		{point + `p(1) != p(2)`, true},
+		{point + `len(p(4))`, 4},
+		{point + `to_string(p(4))`, "Point(4)"},
+		{point + `to_string([p(1)])`, `[Point {x: 1}]`},
+		{point + `len({"x": 4, "y": 1})`, 2},
+		{`enum Money { Cents(n) }; impl Money { "+": fn(a, b) { Cents(a[0] + b[0]) } }; (Cents(1) + Cents(2))[0]`, 3},
+		{`enum Money { Cents(n) }; impl Money { "to_string": fn(m) { to_string(m[0]) + "c" } }; to_string(Cents(5))`, "5c"},
+		{`impl Point { "to_string": fn(p) { 1 } }; to_string(new("Point", {}))`, &object.Error{Message: "to_string must return STRING, got INTEGER"}},
+		{`to_string("a") + to_string(1) + to_string(true)`, "a1true"},
+		{`let f = fn() { spawn(fn() { len(new("Point", {})) }) }; impl Point { "len": fn(p) { 7 } }; recv(f())`, 7},
+	}
+	runVmTests(t, tests)
+
+	errors := map[string]string{
+		point + `p(1) - p(2)`:                                   "unsupported types for binary operation HASH HASH",
+		`impl Point { "<": fn(a, b) { true } }`:                 "cannot implement < for Point",
+		`impl Point { "+": fn(a) { a } }; new("Point", {}) + 1`: "wrong number of arguments: want=1, got=2",
+	}
+	for input, expected := range errors {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", input, expected, err)
+		}
+	}
+}
+
+func TestInExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`2 in [1, 2, 3]`, true},
+		{`4 in [1, 2, 3]`, false},
+		{`"b" in ["a", "b"]`, true},
+		{`[1] in [[1]]`, true},
+		{`let a = [1]; a in [a]`, true},
+		{`"a" in {"a": 1}`, true},
+		{`"b" in {"a": 1}`, false},
+		{`let h = {"gone": first([])}; "gone" in h`, true},
+		{`2 in #{1, 2}`, true},
+		{`5 in 0..5`, false},
+		{`0 in 0..5`, true},
+		{`"key" in "monkey"`, true},
+		{`"" in "monkey"`, true},
+		{`"Key" in "monkey"`, false},
+		{`[x for x in 0..6 if x in [1, 4, 9]]`, []int{1, 4}},
+		{`!(1 in [])`, true},
+	}
+	runVmTests(t, tests)
+
+	errors := map[string]string{
+		`1 in 5`:                "unknown operator: INTEGER in INTEGER",
+		`1 in "abc"`:            "unknown operator: INTEGER in STRING",
+		`[fn() {}] in {"a": 1}`: "unusable as hash key: ARRAY containing CLOSURE",
+		`fn() {} in #{1, 2}`:    "unusable as set element: CLOSURE",
+	}
+	for input, expected := range errors {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", input, expected, err)
+		}
+	}
+}
+
+func TestPipeExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`let double = fn(x) { x * 2 }; 3 |> double`, 6},
+		{`let sub = fn(a, b) { a - b }; 10 |> sub(3)`, 7},
+		{`let map = fn(xs, f) { [f(x) for x in xs] };
+		  let keep = fn(xs, f) { [x for x in xs if f(x)] };
+		  [1, 2, 3, 4] |> keep(fn(x) { x > 2 }) |> map(fn(x) { x * 10 })`, []int{30, 40}},
+		{`"monkey" |> len`, 6},
+		{`1 + 2 |> fn(x) { x * 2 }`, 6},
+		{`[1] |> push(2) |> push(3)`, []int{1, 2, 3}},
+	}
+	runVmTests(t, tests)
+
+	for _, input := range []string{`1 |> 2`, `1 |> 2(3)`} {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != "calling non-function and non-built-in" {
+			t.Errorf("wrong VM error for %q: %v", input, err)
+		}
+	}
+}
+
+func TestComprehensions(t *testing.T) {
+	tests := []vmTestCase{
+		{`[x * 2 for x in [3, -1, 4] if x > 0]`, []int{6, 8}},
+		{`[x for x in []]`, []int{}},
+		{`[i * 10 + x for i, x in [5, 6]]`, []int{5, 16}},
+		{`[x for x in 0..5 if x > 1]`, []int{2, 3, 4}},
+		{`let xs = [1, 2]; [[x, x + 1] for x in xs][1][1]`, 3},
+		{`len([[y for y in 0..x] for x in 0..4][3])`, 3},
+		{`let x = 99; [x for x in [1]]; x`, 99},
+		{`let f = fn(n) { [x * n for x in 1..4] }; f(2)`, []int{2, 4, 6}},
+		{`let fs = [fn() { i } for i in 0..3]; [g() for g in fs]`, []int{0, 1, 2}},
+		{`{k: v * 10 for k, v in {1: 1, 2: 2}}`, map[object.HashKey]int64{
+			(&object.Integer{Value: 1}).HashKey(): 10,
+			(&object.Integer{Value: 2}).HashKey(): 20,
+		}},
+		{`{x: x * x for x in 1..3 if x > 1}`, map[object.HashKey]int64{
+			(&object.Integer{Value: 2}).HashKey(): 4,
+		}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestSets(t *testing.T) {
+	tests := []vmTestCase{
+		{`#{}`, setOf()},
+		{`#{1, 2, 3}`, setOf(1, 2, 3)},
+		{`#{1 + 1, 2, 4 / 2, 3}`, setOf(2, 3)},
+		{`set([3, 1, 3, 2])`, setOf(3, 1, 2)},
+		{`len(#{1, 2, 2, 3})`, 3},
+		{`union(#{1, 2}, #{2, 3})`, setOf(1, 2, 3)},
+		{`intersection(#{1, 2, 3}, #{3, 2, 4})`, setOf(2, 3)},
+		{`difference(#{1, 2, 3}, #{2})`, setOf(1, 3)},
+		{`subset(#{1, 2}, #{1, 2, 3})`, true},
+		{`subset(#{1, 4}, #{1, 2, 3})`, false},
+		{`subset(#{}, #{})`, true},
+		{`has(#{"a", "b"}, "a")`, true},
+		{`has(#{1, 2}, 3)`, false},
+		{`has(#{1, 2}, [1])`, false},
+		{`if (has(#{true}, true)) { 1 } else { 2 }`, 1},
+		{`let s = #{1, 2}; let t = s; has(union(s, #{5}), 5) == true`, true},
+		{`union(#{1}, [1])`, &object.Error{Message: "arguments to `union` must be SET, got ARRAY"}},
+	}
+	runVmTests(t, tests)
+}
+
+func setOf(elements ...int64) *object.Set {
+	set := object.NewSet()
+	for _, el := range elements {
+		set.Add(&object.Integer{Value: el})
+	}
+	return set
+}
+
 func TestIndexExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`[1, 2, 3][1]`, 2},
@@ -135,7 +424,8 @@ func TestIndexExpressions(t *testing.T) {
 		{`[[1, 1, 1]][0][0]`, 1},
 		{`[][0]`, Null},
 		{`[1, 2, 3][99]`, Null},
-		{`[1][-1]`, Null},
+		{`[1][-1]`, 1},
+		{`[1][-2]`, Null},
 		{`{1: 1, 2: 2}[1]`, 1},
 		{`{1: 1, 2: 2}[2]`, 2},
 		{`{1: 1, 2: 2}[0]`, Null},
@@ -144,6 +434,467 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestCallingFunctionsWithoutArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fivePlusTen = fn() { 5 + 10; };
+			fivePlusTen();
+			`,
+			expected: 15,
+		},
+		{
+			input: `
+			let one = fn() { 1; };
+			let two = fn() { 2; };
+			one() + two()
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let earlyExit = fn() { return 99; 100; };
+			earlyExit();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let noReturn = fn() { };
+			noReturn();
+			`,
+			expected: Null,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let identity = fn(a) { a; };
+			identity(4);
+			`,
+			expected: 4,
+		},
+		{
+			input: `
+			let sum = fn(a, b) { a + b; };
+			sum(1, 2);
+			`,
+			expected: 3,
+		},
+		{
+			input: `
+			let sum = fn(a, b) {
+				let c = a + b;
+				c;
+			};
+			let outer = fn() {
+				sum(1, 2) + sum(3, 4);
+			};
+			outer();
+			`,
+			expected: 10,
+		},
+		{
+			input: `
+			let globalNum = 10;
+
+			let sum = fn(a, b) {
+				let c = a + b;
+				c + globalNum;
+			};
+
+			let outer = fn() {
+				sum(1, 2) + sum(3, 4) + globalNum;
+			};
+
+			outer() + globalNum;
+			`,
+			expected: 50,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; if (true) { let a = 2; }; a;", 1},
+		{"let a = 1; if (true) { let a = a + 1; a }", 2},
+		{"let a = 1; let a = 2; a;", 2},
+		{"const a = 1; a;", 1},
+		{"const a = 1; if (true) { let a = 2; a }", 2},
+		{"const a = 1; if (true) { const a = 2; }; a;", 1},
+		{"let f = fn(a) { const a = 2; a }; f(1);", 2},
+		{"let f = fn() { let a = 1; let g = fn() { if (true) { let a = 2; }; a }; g() }; f();", 1},
+		{"let f = fn() { let a = 1; if (true) { let b = a + 1; fn() { a + b } } }; f()();", 3},
+		{"let sum = 0; for (x in [1, 2]) { let sum = x; }; sum;", 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `fn() { 1; }(1);`,
+			expected: `wrong number of arguments: want=0, got=1`,
+		},
+		{
+			input:    `fn(a) { a; }();`,
+			expected: `wrong number of arguments: want=1, got=0`,
+		},
+		{
+			input:    `fn(a, b) { a + b; }(1);`,
+			expected: `wrong number of arguments: want=2, got=1`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{
+			`len(1)`,
+			&object.Error{
+				Message: "argument to `len` not supported, got INTEGER",
+			},
+		},
+		{`len("one", "two")`,
+			&object.Error{
+				Message: "wrong number of arguments. got=2, want=1",
+			},
+		},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`first(1)`,
+			&object.Error{
+				Message: "argument to `first` not supported, got INTEGER",
+			},
+		},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, Null},
+		{`push([], 1)`, []int{1}},
+		{`push(1, 1)`,
+			&object.Error{
+				Message: "argument to `push` not supported, got INTEGER",
+			},
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(split("a,b,,c", ","))`, 4},
+		{`join(split("a,b,c", ","), "-")`, "a-b-c"},
+		{`join([], ", ")`, ""},
+		{`trim("  monkey ")`, "monkey"},
+		{`upper("Monkey") + lower("Monkey")`, "MONKEYmonkey"},
+		{`replace("banana", "a", "o")`, "bonono"},
+		{`contains("monkey", "key")`, true},
+		{`starts_with("monkey", "key")`, false},
+		{`ends_with("monkey", "key")`, true},
+		{`index_of("naïve monkey", "monkey")`, 6},
+		{`index_of("monkey", "ape")`, -1},
+		{`repeat("ab", 3)`, "ababab"},
+		{`pad_left("7", 3, "0") + pad_right("ab", 4) + "|"`, "007ab  |"},
+		{`pad_left("long", 2)`, "long"},
+		{`join(chars("héllo"), " ")`, "h é l l o"},
+		{`split("a", ",", ",")`, &object.Error{Message: "wrong number of arguments. got=3, want=2"}},
+		{`upper(1)`, &object.Error{Message: "argument to `upper` must be STRING, got INTEGER"}},
+		{`repeat("a", "b")`, &object.Error{Message: "second argument to `repeat` must be INTEGER, got STRING"}},
+		{`repeat("a", -1)`, &object.Error{Message: "second argument to `repeat` must not be negative, got -1"}},
+		{`repeat("ab", 9223372036854775807)`, &object.Error{Message: "result of `repeat` would be longer than 1073741824 bytes"}},
+		{`pad_right("a", 9223372036854775807, "é")`, &object.Error{Message: "result of `pad_right` would be longer than 1073741824 bytes"}},
+		{`join([1], ",")`, &object.Error{Message: "elements joined by `join` must be STRING, got INTEGER"}},
+		{`pad_left("a", 3, "ab")`, &object.Error{Message: "third argument to `pad_left` must be a single character, got ab"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`join(keys({"b": 2, "a": 1, "c": 3}), "")`, "bac"},
+		{`keys({10: 1, 9: 2, -1: 3})`, []int{10, 9, -1}},
+		{`values({"b": 2, "a": 1})`, []int{2, 1}},
+		{`map(entries({"b": 2, "a": 1}), fn(e) { e[1] * 10 })`, []int{20, 10}},
+		{`to_string({"b": 2, "a": 1, "c": 3})`, "{b: 2, a: 1, c: 3}"},
+		{`to_string(merge({"b": 2, "a": 1}, {"c": 3, "b": 4}))`, "{b: 4, a: 1, c: 3}"},
+		{`join(keys({k: 0 for k in ["z", "y", "x"]}), "")`, "zyx"},
+		{`join([k for k in {"z": 1, "y": 2, "x": 3}], "")`, "zyx"},
+		{`entries({"a": 1})[0] == ("a", 1)`, true},
+		{`let grid = {[0, 0]: "origin", [1, 2]: "p"}; grid[[1, 2]]`, "p"},
+		{`let seen = {{"b": 2, "a": 1}: true}; seen[{"a": 1, "b": 2}]`, true},
+		{`len(#{[1, [2]], [1, [2]], (1, [2])})`, 2},
+		{`keys({})`, []int{}},
+		{`has({"a": first([])}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`has({"a": 1}, [1])`, false},
+		{`has(#{1}, 1)`, true},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [len(h), len(d), d["b"]]`, []int{2, 1, 2}},
+		{`len(delete({"a": 1}, "z"))`, 1},
+		{`let m = merge({"a": 1, "b": 2}, {"b": 3, "c": 4}); [m["a"], m["b"], m["c"], len(m)]`, []int{1, 3, 4, 3}},
+		{`len({1: 1, 2: 2})`, 2},
+		{`has([1], 1)`, &object.Error{Message: "first argument to `has` must be SET or HASH, got ARRAY"}},
+		{`delete({}, [first])`, &object.Error{Message: "unusable as hash key: ARRAY containing BUILTIN"}},
+		{`merge({}, [])`, &object.Error{Message: "second argument to `merge` must be HASH, got ARRAY"}},
+		{`keys([])`, &object.Error{Message: "argument to `keys` must be HASH, got ARRAY"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestArrayMutation(t *testing.T) {
+	tests := []vmTestCase{
+		{`let a = [1]; append!(a, 2); a`, []int{1, 2}},
+		{`let a = [1, 2, 3]; pop!(a) * 10 + len(a)`, 32},
+		{`pop!([])`, Null},
+		{`let a = [1, 3]; insert!(a, 1, 2); insert!(a, -1, 0)`, []int{1, 2, 3, 0}},
+		{`let a = [1, 2, 3]; remove!(a, 0) + remove!(a, -1) * 10`, 31},
+		{`reverse!([1, 2, 3])`, []int{3, 2, 1}},
+		{`sort!([3, 1, 2])`, []int{1, 2, 3}},
+		{`join(sort!(["b", "c", "a"]), "")`, "abc"},
+		{`let f = fn(xs) { append!(xs, 9) }; let a = [1]; f(a); a`, []int{1, 9}},
+		{`let a = [1, 2]; let b = push(a, 3); reverse!(a); b`, []int{1, 2, 3}},
+		{`let a = push([1], 2); let b = push(a, 3); append!(a, 4); b`, []int{1, 2, 3}},
+		{`let a = [3, 1, 2]; let b = rest(a); sort!(a); b`, []int{1, 2}},
+		{`let a = [1]; let xs = [append!(a, x) for x in 2..4]; a`, []int{1, 2, 3}},
+		{`insert!([1], 3, 0)`, &object.Error{Message: "index 3 out of range for `insert!`"}},
+		{`remove!([1], 1)`, &object.Error{Message: "index 1 out of range for `remove!`"}},
+		{`sort!([1, [2]])`, &object.Error{Message: "cannot compare ARRAY with INTEGER"}},
+		{`let k = [1]; let h = {k: 5}; append!(k, 2); h[[1]]`, 5},
+		{`let k = [1]; let h = {k: 5}; append!(k, 2); k in h`, false},
+		{`let k = [1]; let s = #{k, (k, 2)}; reverse!(append!(k, 0)); has(s, [1]) == has(s, ([1], 2))`, true},
+		{`pop!(keys({[1]: 1})[0])`, &object.Error{Message: "`pop!` cannot change an array used as a hash key or set element"}},
+		{`pop!(1)`, &object.Error{Message: "argument to `pop!` must be ARRAY, got INTEGER"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestSortBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`sort([3, -1, 2, 0])`, []int{-1, 0, 2, 3}},
+		{`join(sort(["pear", "apple", "fig"]), ",")`, "apple,fig,pear"},
+		{`let a = [2, 1]; let b = sort(a); a`, []int{2, 1}},
+		{`sort([1, 3, 2], fn(a, b) { b - a })`, []int{3, 2, 1}},
+		{`let desc = fn(a, b) { b - a }; map(sort([[1, 2], [3]], fn(a, b) { len(a) - len(b) }), fn(xs) { sort(xs, desc)[0] })`, []int{3, 2}},
+		{`join(sort_by(["ccc", "a", "bb", "d"], len), ",")`, "a,d,bb,ccc"},
+		{`map(sort_by([(2, 1), (1, 2), (2, 3)], fn(t) { t[0] }), fn(t) { t[1] })`, []int{2, 1, 3}},
+		{`let a = [1, 3, 2]; sort!(a, fn(x, y) { y - x }); a`, []int{3, 2, 1}},
+		{`sort([2, "a"])`, &object.Error{Message: "cannot compare STRING with INTEGER"}},
+		{`sort_by([1, 2], fn(x) { [x] })`, &object.Error{Message: "cannot compare ARRAY with ARRAY"}},
+		{`sort([1, 2], fn(a, b) { a < b })`, &object.Error{Message: "comparison function for `sort` must return INTEGER, got BOOLEAN"}},
+		{`sort([1, 2], fn(a, b) { a + "" })`, &object.Error{Message: "unsupported types for binary operation INTEGER STRING"}},
+		{`sort_by([1], 2)`, &object.Error{Message: "second argument to `sort_by` must be a function, got INTEGER"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map(0..3, fn(x) { x + 1 })`, []int{1, 2, 3}},
+		{`let k = 10; [1, 2] |> map(fn(x) { x + k })`, []int{11, 12}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`filter([1, 2], fn(x) { first([]) })`, []int{}},
+		{`reduce([1, 2, 3], 10, fn(acc, x) { acc + x })`, 16},
+		{`reduce([], 0, fn(acc, x) { acc + x })`, 0},
+		{`let c = channel(10); each([1, 2], fn(x) { send(c, x) }); recv(c) + recv(c)`, 3},
+		{`each([1, 2], fn(x) { x })`, Null},
+		{`any([1, 2], fn(x) { x > 1 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2], fn(x) { x > 1 })`, false},
+		{`all([], fn(x) { false })`, true},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1], fn(x) { false })`, Null},
+		{`map(zip([1, 2, 3], [10, 20]), fn(t) { t[0] + t[1] })`, []int{11, 22}},
+		{`flat_map([1, 2], fn(x) { [x, x * 10] })`, []int{1, 10, 2, 20}},
+		{`map(["a"], len)`, []int{1}},
+		{`let gen = fn*() { yield 1; yield 2 }; map(gen(), fn(x) { x * 3 })`, []int{3, 6}},
+		{`let outer = fn(xs) { map(xs, fn(x) { reduce(0..x, 0, fn(a, b) { a + b }) }) }; outer([3, 4])`, []int{3, 6}},
+		{`map([1], fn(a, b) { a })`, &object.Error{Message: "wrong number of arguments: want=2, got=1"}},
+		{`map([1], fn(x) { x + "a" })`, &object.Error{Message: "unsupported types for binary operation INTEGER STRING"}},
+		{`map(1, len)`, &object.Error{Message: "first argument to `map` must be iterable, got INTEGER"}},
+		{`reduce([1], 0, 1)`, &object.Error{Message: "third argument to `reduce` must be a function, got INTEGER"}},
+		{`flat_map([1], fn(x) { x })`, &object.Error{Message: "function passed to `flat_map` must return an iterable, got INTEGER"}},
+		{`zip([1])`, &object.Error{Message: "wrong number of arguments. got=1, want at least 2"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let newClosure = fn(a) {
+				fn() { a; };
+			};
+			let closure = newClosure(99);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) {
+				let c = a + b;
+				fn(d) { c + d };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			let wrapper = fn() {
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []vmTestCase{
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g)", 1},
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g); next(g)", 2},
+		{"let gen = fn*() { yield 1; yield 2; }; let g = gen(); next(g); next(g); next(g)", Null},
+		{"let gen = fn*(a, b) { yield a + b; }; next(gen(2, 3))", 5},
+		{"let gen = fn*() { yield 1; return 5; yield 2; }; let g = gen(); next(g); next(g)", Null},
+		{"let gen = fn*() { yield 10; }; first(gen())", 10},
+		{"let gen = fn*() { }; first(gen())", Null},
+		{"let base = 10; let gen = fn*(n) { let x = n + base; yield x; yield x * 2; }; let g = gen(1); next(g) + next(g)", 33},
+		{`
+let counter = fn*(n) {
+	let loop = fn*(i) {
+		yield i;
+		for (x in loop(i + 1)) { yield x; }
+	};
+	for (x in loop(0)) {
+		if (x > n - 1) { return 0; }
+		yield x;
+	}
+};
+let g = counter(3);
+next(g) + next(g) * 10 + next(g) * 100
+`, 210},
+		{"let gen = fn*() { yield -true; }; next(gen())", &object.Error{Message: "unsupported type for negation: BOOLEAN"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let find = fn(xs) { for (x in xs) { if (x > 1) { return x; } } return 0; }; find([1, 2, 3])", 2},
+		{"let gen = fn*() { yield 1; yield 2; }; let f = fn() { for (x in gen()) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let f = fn() { for (x in []) { return 1; } 0 }; f()", 0},
+		{"let f = fn() { for (x in [1, 2]) { x; } }; f()", Null},
+		{"let f = fn(xs) { let a = 1; for (x in xs) { let b = a + x; if (b == 4) { return b; } } a }; f([1, 2, 3])", 4},
+	}
+	runVmTests(t, tests)
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []vmTestCase{
+		{"let c = channel(1); send(c, 5); recv(c)", 5},
+		{"let r = spawn(fn(a, b) { a + b }, 2, 3); recv(r)", 5},
+		{"let c = channel(); spawn(fn() { send(c, 10) }); recv(c)", 10},
+		{"let x = 5; let r = spawn(fn() { x * 2 }); recv(r)", 10},
+		{"let c = channel(10); let worker = fn(n) { send(c, n) }; spawn(worker, 1); spawn(worker, 2); recv(c) + recv(c)", 3},
+		{"let f = fn() { let c = channel(); spawn(fn(n) { send(c, n * 2) }, 21); recv(c) }; f()", 42},
+		{"let c = channel(1); close(c); recv(c)", Null},
+		{"let c = channel(1); send(c, 3); select { case x = recv(c) { x * 2 } }", 6},
+		{"let c = channel(); select { case recv(c) { 1 } default { 2 } }", 2},
+		{"let c = channel(1); select { case send(c, 4) { recv(c) } }", 4},
+		{"let a = channel(); let b = channel(1); send(b, 7); select { case x = recv(a) { x } case y = recv(b) { y + 1 } }", 8},
+		{"let c = channel(1); close(c); select { case x = recv(c) { x } }", Null},
+		{"let c = channel(); select { case recv(c) { 1 } default { } }", Null},
+		{"let c = channel(1); send(c, 1); let f = fn() { select { case x = recv(c) { let y = x + 1; y } } }; f()", 2},
+		{"let c = channel(1); close(c); close(c)", &object.Error{Message: "close of closed channel"}},
+		{"let c = channel(1); close(c); send(c, 1)", &object.Error{Message: "send on closed channel"}},
+		{"recv(spawn(fn() { -true }))", &object.Error{Message: "unsupported type for negation: BOOLEAN"}},
+		{"channel(9223372036854775807)", &object.Error{Message: "channel size 9223372036854775807 is larger than 1048576"}},
+	}
+	runVmTests(t, tests)
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -185,6 +936,15 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 		if actual != Null {
 			t.Errorf("object is not null: %T (%+v)", actual, actual)
 		}
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error: %T (%+v)", actual, actual)
+			return
+		}
+		if errObj.Message != expected.Message {
+			t.Errorf("wrong error message. expected=%q, got=%q", expected.Message, errObj.Message)
+		}
 	case string:
 		err := testStringObject(expected, actual)
 		if err != nil {
@@ -207,20 +967,39 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case *object.Range:
+		r, ok := actual.(*object.Range)
+		if !ok {
+			t.Errorf("object is not Range: got: %T (%+v)", actual, actual)
+			return
+		}
+		if *r != *expected {
+			t.Errorf("wrong range. want=%s, got=%s", expected.Inspect(), r.Inspect())
+		}
+	case *object.Set:
+		set, ok := actual.(*object.Set)
+		if !ok {
+			t.Errorf("object is not Set: got: %T (%+v)", actual, actual)
+			return
+		}
+		if set.Inspect() != expected.Inspect() {
+			t.Errorf("wrong set. want=%s, got=%s", expected.Inspect(), set.Inspect())
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash: got: %T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.OrderedPairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
+				continue
 			}
 
 			err := testIntegerObject(expectedValue, pair.Value)